@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActionMode defines what is done with inactive players
+type ActionMode string
+
+const (
+	// ActionModeDelete deletes inactive players
+	ActionModeDelete ActionMode = "delete"
+	// ActionModeUnsubscribe sets notification_types=-2 and the stale tag keeping the data
+	ActionModeUnsubscribe ActionMode = "unsubscribe"
+	// ActionModeTag only sets the stale tag
+	ActionModeTag ActionMode = "tag"
+)
+
+const (
+	DefaultStaleTag = "stale_since"
+	// DefaultWebhookTimeout limits a webhook request, a hanging endpoint would block a deletion worker otherwise
+	DefaultWebhookTimeout = 30 * time.Second
+	staleTagLayout  = "2006-01-02"
+	// notificationTypesUnsubscribed is notification_types of a player unsubscribed via API
+	notificationTypesUnsubscribed = -2
+)
+
+func ParseActionMode(mode string) (ActionMode, error) {
+	switch ActionMode(strings.ToLower(mode)) {
+	case ActionModeDelete, "":
+		return ActionModeDelete, nil
+	case ActionModeUnsubscribe:
+		return ActionModeUnsubscribe, nil
+	case ActionModeTag:
+		return ActionModeTag, nil
+	default:
+		return "", errors.Errorf("unknown action mode: %s", mode)
+	}
+}
+
+// ActionResult is an outcome of a PlayerAction
+type ActionResult int
+
+const (
+	// ActionResultNone means the player has not been changed, e.g. it has been archived only
+	ActionResultNone ActionResult = iota
+	// ActionResultEdited means the player has been edited
+	ActionResultEdited
+	// ActionResultDeleted means the player has been deleted
+	ActionResultDeleted
+	// ActionResultSkipped means the player must be left as is, the rest of the chain is not applied
+	ActionResultSkipped
+)
+
+// PlayerAction is applied to every inactive player, implementations must be safe for concurrent use
+type PlayerAction interface {
+	Name() string
+	Apply(p Player) (ActionResult, error)
+}
+
+// ActionChain applies actions in order, the chain is stopped by an error or ActionResultSkipped,
+// e.g. a player is not deleted if its archiving has failed.
+type ActionChain []PlayerAction
+
+func (chain ActionChain) Apply(p Player) (ActionResult, error) {
+	result := ActionResultNone
+	for _, action := range chain {
+		r, err := action.Apply(p)
+		if err != nil {
+			return result, errors.Wrapf(err, "error while applying %s-action", action.Name())
+		}
+		if r == ActionResultSkipped {
+			return r, nil
+		}
+		if r > result {
+			result = r
+		}
+	}
+	return result, nil
+}
+
+// actions returns the configured action chain, the chain is built from Action if Actions is empty
+func (c *Cleaner) actions() ActionChain {
+	if len(c.Actions) > 0 {
+		return c.Actions
+	}
+	if c.Action == ActionModeDelete || c.Action == "" {
+		return ActionChain{NewDeleteAction(c)}
+	}
+	return ActionChain{NewStaleAction(c, c.Action == ActionModeUnsubscribe)}
+}
+
+// applyActions applies the action chain to the inactive player counting the result
+func (c *Cleaner) applyActions(p Player, stats *ProcessingProgress) {
+	result, err := c.actions().Apply(p)
+	if err != nil {
+		stats.AddError()
+		c.Logger.
+			WithField("id", p.Id).
+			WithField("last-active", p.LastActive.String()).
+			WithError(err).
+			Errorf("Error while handling an inactive player")
+		return
+	}
+	switch result {
+	case ActionResultDeleted:
+		stats.AddDeleted()
+	case ActionResultEdited:
+		stats.AddEdited()
+	case ActionResultSkipped:
+		stats.AddSkipped()
+	}
+}
+
+// DeleteAction deletes the player, the deletion is recorded to the journal and sampled for the verification
+type DeleteAction struct {
+	cleaner *Cleaner
+}
+
+func NewDeleteAction(c *Cleaner) *DeleteAction {
+	return &DeleteAction{cleaner: c}
+}
+
+func (a *DeleteAction) Name() string {
+	return "delete"
+}
+
+func (a *DeleteAction) Apply(p Player) (ActionResult, error) {
+	err := a.cleaner.deletePlayer(p)
+	if isNotFound(err) {
+		a.cleaner.Logger.
+			WithField("id", p.Id).
+			WithField("target", string(a.cleaner.Target)).
+			Infof("Player has been already deleted")
+		return ActionResultSkipped, nil
+	}
+	if err != nil {
+		return ActionResultNone, err
+	}
+	return ActionResultDeleted, nil
+}
+
+// StaleAction soft-deletes the player marking it with the stale tag (and unsubscribing it if Unsubscribe is set),
+// players soft-deleted for longer than GracePeriod are hard-deleted.
+type StaleAction struct {
+	Unsubscribe bool
+	cleaner     *Cleaner
+}
+
+func NewStaleAction(c *Cleaner, unsubscribe bool) *StaleAction {
+	return &StaleAction{
+		Unsubscribe: unsubscribe,
+		cleaner:     c,
+	}
+}
+
+func (a *StaleAction) Name() string {
+	if a.Unsubscribe {
+		return string(ActionModeUnsubscribe)
+	}
+	return string(ActionModeTag)
+}
+
+func (a *StaleAction) Apply(p Player) (ActionResult, error) {
+	c := a.cleaner
+	// Without tags the stale tag is never found, so players would be re-tagged by every run and never deleted
+	if _, ok := p.Data["tags"]; !ok {
+		return ActionResultNone, errors.New("player data has no tags column, stale players can not be recognized")
+	}
+	if staleSince, ok := c.staleSince(p); ok {
+		if c.GracePeriod > 0 && int(staleSince.Unix()) <= c.Now()-c.GracePeriod {
+			c.Logger.
+				WithField("id", p.Id).
+				WithField("stale-since", staleSince.Format(staleTagLayout)).
+				Infof("Grace period of a stale player has expired, deleting")
+			return NewDeleteAction(c).Apply(p)
+		}
+		c.Logger.
+			WithField("id", p.Id).
+			WithField("stale-since", staleSince.Format(staleTagLayout)).
+			Debugf("Player has been already marked as stale")
+		return ActionResultSkipped, nil
+	}
+	fields := map[string]interface{}{
+		"tags": map[string]string{
+			c.StaleTag: time.Unix(int64(c.Now()), 0).UTC().Format(staleTagLayout),
+		},
+	}
+	if a.Unsubscribe {
+		fields["notification_types"] = notificationTypesUnsubscribed
+	}
+	if err := c.OneSignalClient.EditPlayer(p.Id, fields); err != nil {
+		return ActionResultNone, errors.Wrap(err, "error while marking a player as stale")
+	}
+	c.Logger.
+		WithField("id", p.Id).
+		WithField("last-active", p.LastActive.String()).
+		WithField("action", a.Name()).
+		Infof("Player has been marked as stale")
+	return ActionResultEdited, nil
+}
+
+// ArchivedPlayer is a JSON-representation of an inactive player used by FileAction and WebhookAction
+type ArchivedPlayer struct {
+	AppId      string     `json:"app_id"`
+	Id         string     `json:"id"`
+	LastActive int64      `json:"last_active"`
+	Data       PlayerData `json:"data"`
+}
+
+func newArchivedPlayer(appId string, p Player) ArchivedPlayer {
+	return ArchivedPlayer{
+		AppId:      appId,
+		Id:         p.Id,
+		LastActive: p.LastActive.Unix(),
+		Data:       p.Data,
+	}
+}
+
+// FileAction appends players as JSON lines to a file, e.g. to archive them before the deletion
+type FileAction struct {
+	Filename string
+	AppId    string
+	mu       sync.Mutex
+	f        *os.File
+}
+
+// OpenFileAction opens the file for appending
+func OpenFileAction(filename string, appId string) (*FileAction, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while opening an action file: %s", filename)
+	}
+	return &FileAction{
+		Filename: filename,
+		AppId:    appId,
+		f:        f,
+	}, nil
+}
+
+func (a *FileAction) Name() string {
+	return "file"
+}
+
+func (a *FileAction) Apply(p Player) (ActionResult, error) {
+	line, err := json.Marshal(newArchivedPlayer(a.AppId, p))
+	if err != nil {
+		return ActionResultNone, errors.Wrap(err, "error while player json-encoding")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// A line is written with a single call, so the file is complete even if the process is killed
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		return ActionResultNone, errors.Wrapf(err, "error while writing an action file: %s", a.Filename)
+	}
+	return ActionResultNone, nil
+}
+
+func (a *FileAction) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
+
+// WebhookAction posts players as JSON (see ArchivedPlayer) to the URL, any non-2xx response is an error
+type WebhookAction struct {
+	Url           string
+	AppId         string
+	Headers       http.Header
+	AppHttpClient AppHttpClient
+}
+
+func NewWebhookAction(url string, appId string) *WebhookAction {
+	return &WebhookAction{
+		Url:           url,
+		AppId:         appId,
+		Headers:       http.Header{},
+		AppHttpClient: &http.Client{Timeout: DefaultWebhookTimeout},
+	}
+}
+
+func (a *WebhookAction) Name() string {
+	return "webhook"
+}
+
+func (a *WebhookAction) Apply(p Player) (ActionResult, error) {
+	body, err := json.Marshal(newArchivedPlayer(a.AppId, p))
+	if err != nil {
+		return ActionResultNone, errors.Wrap(err, "error while player json-encoding")
+	}
+	req, err := http.NewRequest(http.MethodPost, a.Url, bytes.NewReader(body))
+	if err != nil {
+		return ActionResultNone, errors.Wrapf(err, "error while creating a webhook request: %s", a.Url)
+	}
+	for name, values := range a.Headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.AppHttpClient.Do(req)
+	if err != nil {
+		return ActionResultNone, errors.Wrapf(err, "error while calling a webhook: %s", a.Url)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return ActionResultNone, errors.Errorf("error response while calling a webhook (%d): %s", resp.StatusCode, string(body))
+	}
+	return ActionResultNone, nil
+}
+
+// requiresTags reports whether the action chain needs tags column of the data, see StaleAction
+func (c *Cleaner) requiresTags() bool {
+	for _, action := range c.actions() {
+		if _, ok := action.(*StaleAction); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// staleSince returns a date of the stale tag of the exported player, tags older than last_active are ignored
+func (c *Cleaner) staleSince(p Player) (time.Time, bool) {
+	v, ok := p.Record.Tags[c.StaleTag]
+	if !ok || v == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(staleTagLayout, v)
+	if err != nil {
+		c.Logger.WithField("id", p.Id).WithField("tag", v).WithError(err).Warningf("Invalid stale tag value")
+		return time.Time{}, false
+	}
+	// The tag is a date, so it is compared with the date of last_active: a tag set before the player has been
+	// active again is outdated and the player is marked once more starting a new grace period
+	if t.Before(p.LastActive.UTC().Truncate(24 * time.Hour)) {
+		c.Logger.
+			WithField("id", p.Id).
+			WithField("stale-since", v).
+			WithField("last-active", p.LastActive.String()).
+			Debugf("Stale tag is older than the last activity, ignoring")
+		return time.Time{}, false
+	}
+	return t, true
+}
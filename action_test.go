@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/mingalevme/gologger"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"onesignal-cleaner/fakeonesignal"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingAction struct {
+	result ActionResult
+	mu     sync.Mutex
+	ids    []string
+}
+
+func (a *recordingAction) Name() string {
+	return "recording"
+}
+
+func (a *recordingAction) Apply(p Player) (ActionResult, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ids = append(a.ids, p.Id)
+	return a.result, nil
+}
+
+func TestActionChain_Apply(t *testing.T) {
+	p := Player{Id: "id1"}
+	first := &recordingAction{result: ActionResultNone}
+	second := &recordingAction{result: ActionResultEdited}
+	result, err := ActionChain{first, second}.Apply(p)
+	assert.NoError(t, err)
+	assert.Equal(t, ActionResultEdited, result)
+
+	skip := &recordingAction{result: ActionResultSkipped}
+	last := &recordingAction{result: ActionResultDeleted}
+	result, err = ActionChain{skip, last}.Apply(p)
+	assert.NoError(t, err)
+	assert.Equal(t, ActionResultSkipped, result)
+	assert.Empty(t, last.ids)
+}
+
+func TestCleaner_Clean_Actions(t *testing.T) {
+	yearAgo := time.Now().AddDate(-1, 0, 0)
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	server.AddPlayer("inactive-1", yearAgo)
+	server.AddPlayer("inactive-2", yearAgo)
+	server.AddPlayer("active", time.Now())
+	server.Start()
+	defer server.Close()
+
+	mu := sync.Mutex{}
+	var posted []ArchivedPlayer
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p ArchivedPlayer
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if p.Id == "inactive-2" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		mu.Lock()
+		posted = append(posted, p)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	filename := t.TempDir() + "/players.jsonl"
+	file, err := OpenFileAction(filename, "app-id")
+	assert.NoError(t, err)
+
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.OriginUrl = server.URL()
+	cleaner.Downloader.Pause = 10 * time.Millisecond
+	cleaner.TmpDir = t.TempDir()
+	cleaner.InactiveFor = 86400 * 30
+	cleaner.Concurrency = 2
+	cleaner.Actions = []PlayerAction{file, NewWebhookAction(webhook.URL, "app-id"), NewDeleteAction(cleaner)}
+
+	assert.NoError(t, cleaner.Clean())
+	assert.NoError(t, file.Close())
+	assert.Equal(t, []string{"inactive-1"}, server.Deleted())
+	assert.Equal(t, []string{"inactive-2", "active"}, server.Players())
+
+	assert.Len(t, posted, 1)
+	assert.Equal(t, "inactive-1", posted[0].Id)
+	assert.Equal(t, "app-id", posted[0].AppId)
+	assert.Equal(t, yearAgo.Unix(), posted[0].LastActive)
+	assert.Equal(t, "1", posted[0].Data["notification_types"])
+
+	f, err := os.Open(filename)
+	assert.NoError(t, err)
+	defer f.Close()
+	var archived []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var p ArchivedPlayer
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &p))
+		archived = append(archived, p.Id)
+	}
+	assert.ElementsMatch(t, []string{"inactive-1", "inactive-2"}, archived)
+}
+
+func TestWebhookAction_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer webhook.Close()
+	defer close(release)
+
+	action := NewWebhookAction(webhook.URL, "app-id")
+	action.AppHttpClient = &http.Client{Timeout: 50 * time.Millisecond}
+	_, err := action.Apply(Player{Id: "id1"})
+	assert.Error(t, err)
+}
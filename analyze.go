@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+type AnalysisFormat string
+
+const (
+	AnalysisFormatText AnalysisFormat = "text"
+	AnalysisFormatJson AnalysisFormat = "json"
+	AnalysisFormatCsv  AnalysisFormat = "csv"
+)
+
+func ParseAnalysisFormat(format string) (AnalysisFormat, error) {
+	switch AnalysisFormat(strings.ToLower(format)) {
+	case AnalysisFormatText, "":
+		return AnalysisFormatText, nil
+	case AnalysisFormatJson:
+		return AnalysisFormatJson, nil
+	case AnalysisFormatCsv:
+		return AnalysisFormatCsv, nil
+	default:
+		return "", errors.Errorf("unknown analysis format: %s", format)
+	}
+}
+
+type CohortCount struct {
+	Value   string  `json:"value"`
+	Players int     `json:"players"`
+	Percent float64 `json:"percent"`
+}
+
+// ThresholdImpact is a number of players an --inactive-for threshold would delete
+type ThresholdImpact struct {
+	InactiveFor int     `json:"inactive_for"`
+	Players     int     `json:"players"`
+	Percent     float64 `json:"percent"`
+}
+
+type Analysis struct {
+	AnalyzedAt         int               `json:"analyzed_at"`
+	Rows               int               `json:"rows"`
+	Errors             int               `json:"errors"`
+	LastActiveAges     []CohortCount     `json:"last_active_ages"`
+	DeviceTypes        []CohortCount     `json:"device_types"`
+	Countries          []CohortCount     `json:"countries"`
+	InvalidIdentifiers []CohortCount     `json:"invalid_identifiers"`
+	Thresholds         []ThresholdImpact `json:"thresholds"`
+}
+
+// Analyzer counts players of an export by cohorts without deleting anything
+type Analyzer struct {
+	// AgeBuckets are upper bounds of last_active age buckets in days
+	AgeBuckets []int
+	// Thresholds are candidate --inactive-for values in seconds
+	Thresholds []int
+	Timestamps *TimestampParser
+	Now        Nower
+}
+
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{
+		AgeBuckets: []int{7, 30, 90, 180, 365, 730},
+		Thresholds: []int{86400 * 30, 86400 * 90, 86400 * 180, 86400 * 365, 86400 * 730},
+		Timestamps: NewTimestampParser(),
+		Now:        Now,
+	}
+}
+
+func (a *Analyzer) Analyze(r RowReader) (*Analysis, error) {
+	buckets := append([]int(nil), a.AgeBuckets...)
+	sort.Ints(buckets)
+	thresholds := append([]int(nil), a.Thresholds...)
+	sort.Ints(thresholds)
+	now := a.Now()
+	ages := make([]int, len(buckets)+2)
+	inactive := make([]int, len(thresholds))
+	deviceTypes := map[string]int{}
+	countries := map[string]int{}
+	invalidIdentifiers := map[string]int{}
+	analysis := &Analysis{AnalyzedAt: now}
+	for i := 1; ; i++ {
+		pd, err := r.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading line #%d", i)
+		}
+		analysis.Rows++
+		record, errs := ParsePlayerRecord(pd, a.Timestamps)
+		if errs.Field("id") != nil || errs.Field("last_active") != nil {
+			analysis.Errors++
+			continue
+		}
+		age := now - int(record.LastActive.Unix())
+		ages[ageBucketIndex(buckets, age)]++
+		for j, threshold := range thresholds {
+			if age >= threshold {
+				inactive[j]++
+			}
+		}
+		deviceTypes[pd["device_type"]]++
+		countries[pd["country"]]++
+		invalidIdentifiers[strconv.FormatBool(record.InvalidIdentifier)]++
+	}
+	valid := analysis.Rows - analysis.Errors
+	for i, n := range ages {
+		analysis.LastActiveAges = append(analysis.LastActiveAges, CohortCount{
+			Value:   ageBucketLabel(buckets, i),
+			Players: n,
+			Percent: percentOf(n, valid),
+		})
+	}
+	analysis.DeviceTypes = cohortCounts(deviceTypes, valid)
+	analysis.Countries = cohortCounts(countries, valid)
+	analysis.InvalidIdentifiers = cohortCounts(invalidIdentifiers, valid)
+	for j, threshold := range thresholds {
+		analysis.Thresholds = append(analysis.Thresholds, ThresholdImpact{
+			InactiveFor: threshold,
+			Players:     inactive[j],
+			Percent:     percentOf(inactive[j], valid),
+		})
+	}
+	return analysis, nil
+}
+
+// ageBucketIndex returns 0 for last_active in the future, len(buckets)+1 for ages over the last bucket
+func ageBucketIndex(buckets []int, age int) int {
+	if age < 0 {
+		return 0
+	}
+	for i, days := range buckets {
+		if age <= days*86400 {
+			return i + 1
+		}
+	}
+	return len(buckets) + 1
+}
+
+func ageBucketLabel(buckets []int, i int) string {
+	switch {
+	case i == 0:
+		return "future"
+	case i > len(buckets):
+		return fmt.Sprintf(">%dd", buckets[len(buckets)-1])
+	case i == 1:
+		return fmt.Sprintf("<=%dd", buckets[0])
+	default:
+		return fmt.Sprintf("%d-%dd", buckets[i-2]+1, buckets[i-1])
+	}
+}
+
+func cohortCounts(counts map[string]int, total int) []CohortCount {
+	cohorts := make([]CohortCount, 0, len(counts))
+	for value, n := range counts {
+		if value == "" {
+			value = "unknown"
+		}
+		cohorts = append(cohorts, CohortCount{Value: value, Players: n, Percent: percentOf(n, total)})
+	}
+	sort.Slice(cohorts, func(i, j int) bool {
+		if cohorts[i].Players != cohorts[j].Players {
+			return cohorts[i].Players > cohorts[j].Players
+		}
+		return cohorts[i].Value < cohorts[j].Value
+	})
+	return cohorts
+}
+
+func percentOf(n int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(int(float64(n)*10000/float64(total)+0.5)) / 100
+}
+
+// Analyze reads the inputs (de-duplicating players of multiple inputs) and analyzes them
+func (c *Cleaner) Analyze(analyzer *Analyzer, inputs ...string) (*Analysis, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("no data files to analyze")
+	}
+	var r RowReader
+	var err error
+	if len(inputs) > 1 {
+		r, err = c.mergeInputs(inputs)
+	} else {
+		r, err = c.openInput(inputs[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if hr, ok := r.(headerReader); ok {
+		if err := c.validateHeader(hr.Header()); err != nil {
+			return nil, err
+		}
+	}
+	return analyzer.Analyze(r)
+}
+
+func WriteAnalysis(w io.Writer, a *Analysis, format AnalysisFormat) error {
+	switch format {
+	case AnalysisFormatJson:
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(a)
+	case AnalysisFormatCsv:
+		return writeAnalysisCsv(w, a)
+	default:
+		return writeAnalysisText(w, a)
+	}
+}
+
+func writeAnalysisCsv(w io.Writer, a *Analysis) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"section", "value", "players", "percent"})
+	sections := []struct {
+		name    string
+		cohorts []CohortCount
+	}{
+		{"last_active_age", a.LastActiveAges},
+		{"device_type", a.DeviceTypes},
+		{"country", a.Countries},
+		{"invalid_identifier", a.InvalidIdentifiers},
+	}
+	for _, section := range sections {
+		for _, cohort := range section.cohorts {
+			_ = cw.Write([]string{section.name, cohort.Value, strconv.Itoa(cohort.Players), formatPercent(cohort.Percent)})
+		}
+	}
+	for _, t := range a.Thresholds {
+		_ = cw.Write([]string{"inactive_for", strconv.Itoa(t.InactiveFor), strconv.Itoa(t.Players), formatPercent(t.Percent)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeAnalysisText(w io.Writer, a *Analysis) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Rows:\t%d\t\n", a.Rows)
+	_, _ = fmt.Fprintf(tw, "Errors:\t%d\t\n", a.Errors)
+	sections := []struct {
+		title   string
+		cohorts []CohortCount
+	}{
+		{"Last active age", a.LastActiveAges},
+		{"Device type", a.DeviceTypes},
+		{"Country", a.Countries},
+		{"Invalid identifier", a.InvalidIdentifiers},
+	}
+	for _, section := range sections {
+		_, _ = fmt.Fprintf(tw, "\t\t\t\n%s\tPlayers\t%%\t\n", section.title)
+		for _, cohort := range section.cohorts {
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\t\n", cohort.Value, cohort.Players, formatPercent(cohort.Percent))
+		}
+	}
+	_, _ = fmt.Fprintf(tw, "\t\t\t\nInactive for (days)\tWould delete\t%%\t\n")
+	for _, t := range a.Thresholds {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\t\n", strconv.FormatFloat(float64(t.InactiveFor)/86400, 'f', -1, 64), t.Players, formatPercent(t.Percent))
+	}
+	return tw.Flush()
+}
+
+func formatPercent(p float64) string {
+	return strconv.FormatFloat(p, 'f', 2, 64)
+}
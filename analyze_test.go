@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mingalevme/gologger"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleaner_Analyze(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := func(days int) string {
+		return now.AddDate(0, 0, -days).Format(OneSignalTimeLayout)
+	}
+	filename := t.TempDir() + "/export.csv.gz"
+	writeGzCsv(t, filename, [][]string{
+		{"id", "last_active", "device_type", "country", "invalid_identifier"},
+		{"id1", day(1), "0", "US", "f"},
+		{"id2", day(20), "1", "US", "t"},
+		{"id3", day(100), "1", "DE", "f"},
+		{"id4", day(400), "1", "", "f"},
+		{"id5", day(-1), "5", "DE", "f"},
+		{"id6", "invalid", "1", "DE", "f"},
+	})
+	cleaner := NewCleaner("", "", gologger.NewNullLogger())
+	analyzer := NewAnalyzer()
+	analyzer.AgeBuckets = []int{30, 365}
+	analyzer.Thresholds = []int{86400 * 365, 86400 * 10}
+	analyzer.Now = func() int {
+		return int(now.Unix())
+	}
+	analysis, err := cleaner.Analyze(analyzer, filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, analysis.Rows)
+	assert.Equal(t, 1, analysis.Errors)
+	assert.Equal(t, []CohortCount{
+		{"future", 1, 20},
+		{"<=30d", 2, 40},
+		{"31-365d", 1, 20},
+		{">365d", 1, 20},
+	}, analysis.LastActiveAges)
+	assert.Equal(t, []CohortCount{
+		{"1", 3, 60},
+		{"0", 1, 20},
+		{"5", 1, 20},
+	}, analysis.DeviceTypes)
+	assert.Equal(t, []CohortCount{
+		{"DE", 2, 40},
+		{"US", 2, 40},
+		{"unknown", 1, 20},
+	}, analysis.Countries)
+	assert.Equal(t, []CohortCount{
+		{"false", 4, 80},
+		{"true", 1, 20},
+	}, analysis.InvalidIdentifiers)
+	assert.Equal(t, []ThresholdImpact{
+		{86400 * 10, 3, 60},
+		{86400 * 365, 1, 20},
+	}, analysis.Thresholds)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, WriteAnalysis(buf, analysis, AnalysisFormatJson))
+	var decoded Analysis
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, *analysis, decoded)
+
+	buf.Reset()
+	assert.NoError(t, WriteAnalysis(buf, analysis, AnalysisFormatCsv))
+	assert.Contains(t, buf.String(), "section,value,players,percent\n")
+	assert.Contains(t, buf.String(), "inactive_for,864000,3,60.00\n")
+
+	buf.Reset()
+	assert.NoError(t, WriteAnalysis(buf, analysis, AnalysisFormatText))
+	assert.Contains(t, buf.String(), "Would delete")
+	assert.True(t, strings.Contains(buf.String(), "365 "))
+}
+
+func TestParseAnalysisFormat(t *testing.T) {
+	format, err := ParseAnalysisFormat("JSON")
+	assert.NoError(t, err)
+	assert.Equal(t, AnalysisFormatJson, format)
+	_, err = ParseAnalysisFormat("xml")
+	assert.Error(t, err)
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 	"github.com/mingalevme/gologger"
 	"github.com/pkg/errors"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -14,66 +17,266 @@ type PlayerData map[string]string
 type Player struct {
 	Id         string
 	LastActive time.Time
+	Record     PlayerRecord
+	// Data is the raw exported row
+	Data PlayerData
 }
 
 type Cleaner struct {
 	OneSignalClient    *OneSignalClient
 	Downloader         *Downloader
 	GzCsvReaderFactory func(filename string) (*GzCsvReader, error)
-	Logger             gologger.Logger
-	InactiveFor        int
-	ConnectionTimeout  int
-	TmpDir             string
-	Concurrency        int
-	DownloadOnly       bool
-	Now                Nower
+	// GzCsvStreamReaderFactory is used in Stream-mode
+	GzCsvStreamReaderFactory func(r io.Reader) (*GzCsvReader, error)
+	CsvOptions               CsvOptions
+	Timestamps               *TimestampParser
+	FutureLastActive         FutureLastActivePolicy
+	Logger                   gologger.Logger
+	InactiveFor              int
+	ConnectionTimeout        int
+	TmpDir                   string
+	Concurrency              int
+	// ParseWorkers enables the pipelined players handling (see handlePlayersPipeline) if positive
+	ParseWorkers     int
+	DownloadOnly     bool
+	Stream           bool
+	StreamArchive    bool
+	MaxExportAge     int
+	DeleteDataFile   bool
+	KeepLastExports  int
+	KeepExportsFor   int
+	MinFreeDiskSpace uint64
+	VerifyDataFile   bool
+	Progress         *ProgressReporter
+	// Sink is optional, data files and run reports are uploaded to it
+	Sink ArchiveSink
+	// Journal is optional, successfully deleted players are recorded to it
+	Journal *DeletionJournal
+	// VerifyDeletions is a ratio (0..1) of deleted players re-fetched after VerifyDeletionsDelay to check
+	// they are really deleted, 0 disables the verification
+	VerifyDeletions      float64
+	VerifyDeletionsDelay time.Duration
+	// RetryUndeleted retries deletion of players still existing after the verification
+	RetryUndeleted bool
+	// CheckFreshness fetches every inactive player before the deletion and skips it
+	// if it is not inactive according to the live last_active
+	CheckFreshness bool
+	// Action is what is done with inactive players if Actions is empty, see ActionMode
+	Action ActionMode
+	// Source is where players are read from, a CSV export (see ExportSource, Stream) if nil
+	Source PlayerSource
+	// Target is what is deleted for an inactive player, see DeletionTarget
+	Target DeletionTarget
+	// UserAlias is an alias label users are deleted by if Target is DeletionTargetUser, e.g. external_id
+	UserAlias string
+	// Actions is an optional chain of actions applied to inactive players, e.g. archive then delete
+	Actions []PlayerAction
+	// StaleTag is a tag soft-deleted players are marked with, its value is a date (YYYY-MM-DD)
+	StaleTag string
+	// GracePeriod is a time in seconds players are soft-deleted for before the hard deletion, 0 disables it
+	GracePeriod int
+	Now         Nower
+	deletions   deletionSample
+	// userDeletions are results of user deletions by alias, see DeletionTargetUser
+	userDeletions userDeletions
 }
 
 func NewCleaner(appId string, restApiKey string, logger gologger.Logger) *Cleaner {
 	osc := NewOneSignalClient(appId, restApiKey)
 	osc.Logger = logger
+	progress := NewProgressReporter(logger)
 	d := NewDownloader()
 	d.Logger = logger
-	return &Cleaner{
-		OneSignalClient: osc,
-		Downloader:      d,
-		GzCsvReaderFactory: func(filename string) (*GzCsvReader, error) {
-			return NewGzCsvReader(filename)
-		},
-		InactiveFor: 86400 * 30 * 6,
-		TmpDir:      os.TempDir(),
-		Concurrency: 1,
-		Now:         Now,
-		Logger:      logger,
+	d.Progress = progress
+	c := &Cleaner{
+		OneSignalClient:      osc,
+		Downloader:           d,
+		CsvOptions:           DefaultCsvOptions(),
+		Timestamps:           NewTimestampParser(),
+		FutureLastActive:     FutureLastActiveActive,
+		InactiveFor:          86400 * 30 * 6,
+		TmpDir:               os.TempDir(),
+		Concurrency:          1,
+		VerifyDataFile:       true,
+		VerifyDeletionsDelay: time.Minute,
+		Action:               ActionModeDelete,
+		StaleTag:             DefaultStaleTag,
+		Target:               DeletionTargetPlayer,
+		UserAlias:            AliasExternalId,
+		Progress:             progress,
+		Now:                  Now,
+		Logger:               logger,
 	}
+	c.GzCsvReaderFactory = func(filename string) (*GzCsvReader, error) {
+		return NewGzCsvReader(filename, c.csvOptions())
+	}
+	c.GzCsvStreamReaderFactory = func(r io.Reader) (*GzCsvReader, error) {
+		return NewGzCsvReaderFromReader(r, c.csvOptions())
+	}
+	return c
 }
 
 func (c *Cleaner) Clean(localFileName ...string) error {
-	var fileName string
-	var err error
-	if len(localFileName) > 0 && localFileName[0] != "" {
-		fileName = localFileName[0]
-		c.Logger.WithField("file", fileName).Infof("Reading data from a local file")
+	report := NewRunReport(c.OneSignalClient.AppId, c.InactiveFor, c.Now())
+	err := c.clean(report, localFileName...)
+	// A failed run may have deleted players too
+	c.verifyDeletions(report)
+	report.Finish(c.Now(), err)
+	c.uploadReport(report)
+	return err
+}
+
+func (c *Cleaner) clean(report *RunReport, localFileName ...string) error {
+	var inputs []string
+	for _, input := range localFileName {
+		if input != "" {
+			inputs = append(inputs, input)
+		}
+	}
+	if len(inputs) > 0 {
+		return c.cleanInputs(report, inputs)
+	}
+	if c.Source != nil {
+		return c.cleanSource(report, c.Source)
+	}
+	if c.DownloadOnly {
+		return c.download()
+	}
+	if c.Stream {
+		if _, ok := c.getCachedExport(); !ok {
+			return c.cleanStream(report)
+		}
+	}
+	return c.cleanSource(report, NewExportSource(c))
+}
+
+// download fetches a data file (unless there is a fresh cached one) without handling players
+func (c *Cleaner) download() error {
+	if e, ok := c.getCachedExport(); ok {
+		c.Logger.
+			WithField("file", e.Filename).
+			WithField("created-at", e.CreatedAt.String()).
+			Infof("Reusing a cached data file")
 	} else {
-		fileName, err = c.fetchData()
+		fileName, err := c.fetchData()
 		if err != nil {
 			return errors.Wrap(err, "error while fetching a data file")
 		}
-		if c.DownloadOnly {
-			c.Logger.WithField("file", fileName).Infof("Data file has been fetched")
-			return nil
+		c.uploadDataFile(fileName)
+		c.Logger.WithField("file", fileName).Infof("Data file has been fetched")
+	}
+	c.applyRetention()
+	return nil
+}
+
+// cleanStream handles players while the data is being downloaded without storing it to a disk,
+// the data is stored to a file in TmpDir only if StreamArchive is set.
+func (c *Cleaner) cleanStream(report *RunReport) error {
+	dataUrl, err := c.OneSignalClient.GetExportUrl()
+	if err != nil {
+		return errors.Wrap(err, "error while getting export url")
+	}
+	c.Logger.Infof("Export url has been fetched: %s", dataUrl)
+	body, err := c.Downloader.Open(dataUrl)
+	if err != nil {
+		return errors.Wrap(err, "error while opening a data stream")
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(body)
+	var src io.Reader = body
+	var archive *os.File
+	fileName := c.getDestFileName()
+	partFileName := fileName + ".part"
+	if c.StreamArchive {
+		if err := c.checkFreeDiskSpace(); err != nil {
+			return err
+		}
+		archive, err = os.Create(partFileName)
+		if err != nil {
+			return errors.Wrap(err, "error while creating an archive file")
 		}
+		defer func(f *os.File) {
+			_ = f.Close()
+		}(archive)
+		src = io.TeeReader(body, archive)
+		c.Logger.WithField("file", fileName).Infof("Archiving data stream into a file")
 	}
-	c.Logger.Infof("Starting data file reading ...")
-	r, err := c.GzCsvReaderFactory(fileName)
+	c.Logger.WithField("url", dataUrl).Infof("Starting data stream reading ...")
+	r, err := c.GzCsvStreamReaderFactory(src)
 	if err != nil {
 		return errors.Wrap(err, "error while creating/initializing gz-csv-reader")
 	}
 	defer r.Close()
+	stats, err := c.handlePlayers(r)
+	report.SetStats(stats)
+	if err != nil {
+		if archive != nil {
+			_ = archive.Close()
+			_ = os.Remove(partFileName)
+		}
+		return err
+	}
+	c.Logger.Infof("Cleaning has been finished: %d players have been deleted", stats.Deleted)
+	if archive == nil {
+		return nil
+	}
+	// Draining the rest of the stream (e.g. a gzip trailer) so the archive is complete
+	if _, err := io.Copy(ioutil.Discard, src); err != nil {
+		_ = archive.Close()
+		_ = os.Remove(partFileName)
+		return errors.Wrap(err, "error while archiving a data stream")
+	}
+	_ = archive.Close()
+	if err := os.Rename(partFileName, fileName); err != nil {
+		return errors.Wrap(err, "error while renaming an archive file")
+	}
+	c.Logger.WithField("file", fileName).Infof("Data stream has been archived")
+	report.DataFile = fileName
+	c.uploadDataFile(fileName)
+	c.applyRetention()
+	return nil
+}
+
+// cleanInputs handles players of several inputs (local files, stdin or remote files), players of multiple inputs
+// are de-duplicated
+func (c *Cleaner) cleanInputs(report *RunReport, inputs []string) error {
+	var r RowReader
+	var err error
+	if len(inputs) == 1 {
+		r, err = c.openInput(inputs[0])
+	} else {
+		c.Logger.WithField("inputs", inputs).Infof("Merging inputs ...")
+		r, err = c.mergeInputs(inputs)
+	}
+	if err != nil {
+		return errors.Wrap(err, "error while opening inputs")
+	}
+	defer r.Close()
+	report.DataFile = strings.Join(inputs, ",")
+	stats, err := c.handlePlayers(r)
+	report.SetStats(stats)
+	if err != nil {
+		return err
+	}
+	c.Logger.Infof("Cleaning has been finished: %d players have been deleted", stats.Deleted)
+	return nil
+}
+
+func (c *Cleaner) handlePlayers(r RowReader) (ProcessingProgress, error) {
+	if hr, ok := r.(headerReader); ok {
+		if err := c.validateHeader(hr.Header()); err != nil {
+			return ProcessingProgress{}, err
+		}
+	}
+	if c.ParseWorkers > 0 {
+		return c.handlePlayersPipeline(r)
+	}
 	throttle := make(chan struct{}, c.Concurrency)
 	wg := sync.WaitGroup{}
 	c.Logger.Infof("Starting players handling ...")
-	deleted := 0
+	stats := NewProcessingProgress()
+	users := c.newUserGroups()
 	i := 0
 	for {
 		i += 1
@@ -84,56 +287,52 @@ func (c *Cleaner) Clean(localFileName ...string) error {
 				c.Logger.Debugf("EOF")
 				break
 			}
-			return errors.Wrapf(err, "error reading line #%d", i)
+			wg.Wait()
+			c.Progress.Done("Players handling has been interrupted", stats)
+			return stats.Snapshot(), errors.Wrapf(err, "error reading line #%d", i)
 		}
+		stats.AddRow()
+		c.Progress.Report("Handling players", stats)
 		c.Logger.Debugf("Row #%d: %v", i, pd)
-		p, err := c.unmarshalPlayerData(pd)
-		if err != nil {
-			c.Logger.
-				WithField("id", pd["id"]).
-				WithField("last-active", pd["last_active"]).
-				WithError(err).
-				Errorf("Error while unmarshalling a player data")
+		p, ok := c.evaluatePlayerData(pd, stats)
+		if !ok {
+			users.protect(pd)
 			continue
 		}
-		if int(p.LastActive.Unix()) > c.Now()-c.InactiveFor {
-			c.Logger.
-				WithField("id", p.Id).
-				WithField("last-active", p.LastActive.String()).
-				Infof("Player is active")
+		if users.postpone(p) {
 			continue
 		}
-		c.Logger.
-			WithField("id", p.Id).
-			WithField("last-active", p.LastActive.String()).
-			Infof("Player is inactive")
 		c.Logger.Debugf("Scheduling player for a deletion: %s", pd["id"])
 		throttle <- struct{}{}
 		wg.Add(1)
 		go func(n int) {
 			c.Logger.WithField("player", p.Id).Debugf("Starting a player deletion ...")
-			c.deletePlayer(p)
+			c.handleInactivePlayer(p, stats)
 			c.Logger.WithField("player", p.Id).Debugf("Player deletion has been finished")
 			<-throttle
 			wg.Done()
 		}(i)
-		deleted += 1
 	}
 	wg.Wait()
 	close(throttle)
-	c.Logger.Infof("Consider deleting a data file: %s", fileName)
-	c.Logger.Infof("Cleaning has been finished: %d players have been deleted", deleted)
-	return nil
+	c.handleUserGroups(users, stats)
+	c.Progress.Done("Players have been handled", stats)
+	return stats.Snapshot(), nil
 }
 
 func (c *Cleaner) fetchData() (string, error) {
+	if err := c.checkFreeDiskSpace(); err != nil {
+		return "", err
+	}
 	dataUrl, err := c.OneSignalClient.GetExportUrl()
 	if err != nil {
 		return "", errors.Wrap(err, "error while getting export url")
 	}
 	c.Logger.Infof("Export url has been fetched: %s", dataUrl)
 	fileName := c.getDestFileName()
-	f, err := os.Create(fileName)
+	// Downloading into a temporary file so an incomplete download is never taken for a cached export
+	partFileName := fileName + ".part"
+	f, err := os.Create(partFileName)
 	if err != nil {
 		return "", errors.Wrap(err, "error while creating a temporary file")
 	}
@@ -147,43 +346,237 @@ func (c *Cleaner) fetchData() (string, error) {
 	err = c.Downloader.Download(dataUrl, f)
 	_ = f.Close()
 	if err != nil {
+		_ = os.Remove(partFileName)
 		return "", errors.Wrap(err, "error while downloading data")
 	}
+	// Exports are always compressed, an uncompressed file is e.g. an HTML error page
+	compression, err := DetectFileCompression(partFileName)
+	if err == nil && compression == CompressionNone {
+		err = errors.New("data file is not compressed")
+	}
+	if err != nil {
+		_ = os.Remove(partFileName)
+		return "", errors.Wrap(err, "error while verifying a data file")
+	}
+	if c.VerifyDataFile {
+		c.Logger.WithField("file", partFileName).Infof("Verifying data file integrity ...")
+		if err = VerifyDataFile(partFileName); err != nil {
+			_ = os.Remove(partFileName)
+			return "", errors.Wrap(err, "error while verifying a data file")
+		}
+	}
+	if err = os.Rename(partFileName, fileName); err != nil {
+		return "", errors.Wrap(err, "error while renaming a temporary file")
+	}
 	c.Logger.Infof("Players have been fetched to a file: %s", fileName)
 	return fileName, nil
 }
 
+// RequiredColumns returns data file columns needed to handle players
+func (c *Cleaner) RequiredColumns() []string {
+	return []string{"id", "last_active"}
+}
+
+func (c *Cleaner) validateHeader(header []string) error {
+	unexpected, err := ValidateHeader(header, c.RequiredColumns(), OneSignalExportColumns)
+	if err != nil {
+		return err
+	}
+	if c.requiresTags() && indexOf(header, "tags") < 0 {
+		return errors.New("data file has no tags column required by soft deletion")
+	}
+	if len(unexpected) > 0 {
+		c.Logger.WithField("columns", unexpected).Warningf("Data file has unexpected columns")
+	}
+	return nil
+}
+
+func (c *Cleaner) csvOptions() CsvOptions {
+	options := c.CsvOptions
+	options.AsyncDecompression = options.AsyncDecompression || c.ParseWorkers > 0
+	if options.OnMalformedRow == nil {
+		options.OnMalformedRow = func(err *MalformedRowError) {
+			c.Logger.WithField("line", err.Line).WithError(err.Err).Warningf("Malformed row has been skipped")
+		}
+	}
+	return options
+}
+
 func (c *Cleaner) unmarshalPlayerData(pd PlayerData) (Player, error) {
 	// last_active:2018-10-26 08:48:42
 	// id:059f4d57-xxxx-xxxx-xxxx-fa83792fd276
-	p := Player{
-		Id: pd["id"],
+	r, errs := ParsePlayerRecord(pd, c.Timestamps)
+	for _, field := range c.RequiredColumns() {
+		if err := errs.Field(field); err != nil {
+			return Player{}, err
+		}
 	}
-	lastActive, err := time.Parse("2006-01-02 15:04:05", pd["last_active"])
-	if err != nil {
-		return Player{}, errors.Wrapf(err, "error while parsing last active: %s", pd["last_active"])
+	if len(errs) > 0 {
+		c.Logger.WithField("id", r.Id).WithError(errs).Debugf("Error while parsing optional player fields")
+	}
+	return Player{
+		Id:         r.Id,
+		LastActive: r.LastActive,
+		Record:     r,
+		Data:       pd,
+	}, nil
+}
+
+func (c *Cleaner) isInactive(p Player) (bool, error) {
+	lastActive := int(p.LastActive.Unix())
+	if lastActive > c.Now() {
+		switch c.FutureLastActive {
+		case FutureLastActiveSkip:
+			return false, errors.Errorf("last active is in the future: %s", p.LastActive.String())
+		case FutureLastActiveInactive:
+			return true, nil
+		}
+	}
+	return lastActive <= c.Now()-c.InactiveFor, nil
+}
+
+// handleInactivePlayer applies the action to the player unless the freshness check skips it
+func (c *Cleaner) handleInactivePlayer(p Player, stats *ProcessingProgress) {
+	if c.CheckFreshness {
+		inactive, err := c.isStillInactive(p)
+		if err != nil {
+			stats.AddError()
+			c.Logger.
+				WithField("id", p.Id).
+				WithField("last-active", p.LastActive.String()).
+				WithError(err).
+				Errorf("Error while checking a player freshness")
+			return
+		}
+		if !inactive {
+			stats.AddSkipped()
+			return
+		}
 	}
-	p.LastActive = lastActive
-	return p, nil
+	c.applyActions(p, stats)
 }
 
-func (c *Cleaner) deletePlayer(p Player) {
-	err := c.OneSignalClient.DeletePlayer(p.Id)
+// isStillInactive applies the inactivity policy to the live player record
+func (c *Cleaner) isStillInactive(p Player) (bool, error) {
+	player, err := c.OneSignalClient.GetPlayer(p.Id)
+	if err == ErrPlayerNotFound {
+		c.Logger.WithField("id", p.Id).Infof("Player does not exist anymore, skipping")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// The freshness check must never be the reason of a deletion, so an unknown live last_active skips the player
+	if player.LastActive <= 0 {
+		c.Logger.
+			WithField("id", p.Id).
+			WithField("last-active", p.LastActive.String()).
+			Warningf("Live last active of a player is unknown, skipping")
+		return false, nil
+	}
+	live := p
+	live.LastActive = time.Unix(player.LastActive, 0).UTC()
+	inactive, err := c.isInactive(live)
 	if err != nil {
+		return false, errors.Wrap(err, "error while applying the inactivity policy to the live player")
+	}
+	if !inactive {
 		c.Logger.
 			WithField("id", p.Id).
 			WithField("last-active", p.LastActive.String()).
-			WithError(err).
-			Errorf("Error while deleting a player")
-		return
+			WithField("live-last-active", live.LastActive.String()).
+			Infof("Player is not inactive anymore, skipping")
+		return false, nil
+	}
+	return true, nil
+}
+
+// deletePlayer deletes the player (see Target) recording it to the journal and sampling it for the verification
+func (c *Cleaner) deletePlayer(p Player) error {
+	if err := c.deleteTargetOnce(p); err != nil {
+		return err
 	}
 	c.Logger.
 		WithField("id", p.Id).
 		WithField("last-active", p.LastActive.String()).
 		Infof("User has been deleted successfully")
+	if err := c.Journal.Record(p); err != nil {
+		c.Logger.WithField("id", p.Id).WithError(err).Errorf("Error while recording a player deletion")
+	}
+	c.deletions.add(p, c.VerifyDeletions)
+	return nil
+}
+
+func (c *Cleaner) getCachedExport() (CachedExport, bool) {
+	if c.MaxExportAge <= 0 {
+		return CachedExport{}, false
+	}
+	e, ok, err := c.ExportCache().Fresh(c.MaxExportAge)
+	if err != nil {
+		c.Logger.WithError(err).Warningf("Error while looking up a cached data file")
+		return CachedExport{}, false
+	}
+	if !ok {
+		c.Logger.WithField("max-export-age", c.MaxExportAge).Infof("No fresh cached data file has been found")
+	}
+	return e, ok
+}
+
+func (c *Cleaner) applyRetention() {
+	_, err := c.ExportCache().ApplyRetention(c.KeepLastExports, c.KeepExportsFor)
+	if err != nil {
+		c.Logger.WithError(err).Errorf("Error while applying the data files retention policy")
+	}
+}
+
+func (c *Cleaner) checkFreeDiskSpace() error {
+	if c.MinFreeDiskSpace == 0 {
+		return nil
+	}
+	free, err := getFreeDiskSpace(c.TmpDir)
+	if err != nil {
+		c.Logger.WithField("dir", c.TmpDir).WithError(err).Warningf("Error while getting free disk space, skipping the check")
+		return nil
+	}
+	if free < c.MinFreeDiskSpace {
+		return errors.Errorf("not enough free disk space in %s: %d bytes available, at least %d bytes required", c.TmpDir, free, c.MinFreeDiskSpace)
+	}
+	c.Logger.WithField("dir", c.TmpDir).WithField("free", free).Debugf("Free disk space has been checked")
+	return nil
+}
+
+func (c *Cleaner) uploadDataFile(fileName string) {
+	if c.Sink == nil {
+		return
+	}
+	key := c.OneSignalClient.AppId + "/" + filepath.Base(fileName)
+	if err := c.Sink.UploadFile(key, fileName); err != nil {
+		c.Logger.WithField("file", fileName).WithError(err).Errorf("Error while uploading a data file")
+	}
+}
+
+func (c *Cleaner) uploadReport(report *RunReport) {
+	if c.Sink == nil {
+		return
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		c.Logger.WithError(err).Errorf("Error while encoding a run report")
+		return
+	}
+	key := c.OneSignalClient.AppId + "/" + report.FileName()
+	if err := c.Sink.Upload(key, data, "application/json"); err != nil {
+		c.Logger.WithError(err).Errorf("Error while uploading a run report")
+	}
+}
+
+func (c *Cleaner) ExportCache() *ExportCache {
+	cache := NewExportCache(c.TmpDir, c.OneSignalClient.AppId)
+	cache.Now = c.Now
+	cache.Logger = c.Logger
+	return cache
 }
 
 func (c *Cleaner) getDestFileName() string {
-	now := time.Unix(int64(c.Now()), 0).Format("20060102150405")
-	return fmt.Sprintf("%s/onesignal-players-%s-%s.csv.gz", c.TmpDir, c.OneSignalClient.AppId, now)
+	return c.ExportCache().FileName(c.Now())
 }
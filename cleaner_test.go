@@ -2,12 +2,18 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"github.com/mingalevme/gologger"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"onesignal-cleaner/fakeonesignal"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCleaner_Clean(t *testing.T) {
@@ -28,12 +34,14 @@ func TestCleaner_Clean(t *testing.T) {
 	oneSignal.Logger = logger
 
 	// Downloader
+	data, err := ioutil.ReadFile("gz_csv_reader_test_data.csv.gz")
+	assert.NoError(t, err)
 	downloaderAppHttpClient := NewQueueResponseAppHttpClient()
 	downloaderAppHttpClient.Enqueue(&http.Response{
 		StatusCode: 200,
-		Body:       ioutil.NopCloser(bytes.NewBufferString("foobar")),
+		Body:       ioutil.NopCloser(bytes.NewBuffer(data)),
 		Header: map[string][]string{
-			"Content-Length": {"6"},
+			"Content-Length": {strconv.Itoa(len(data))},
 		},
 	})
 	downloader := NewDownloader()
@@ -50,11 +58,508 @@ func TestCleaner_Clean(t *testing.T) {
 	cleaner.OneSignalClient = oneSignal
 	cleaner.Downloader = downloader
 	cleaner.GzCsvReaderFactory = gzCsvReaderFactory
+	cleaner.TmpDir = t.TempDir()
+
+	err = cleaner.Clean()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 0, oneSignalAppHttpClient.Size())
+}
+
+func TestCleaner_Clean_CachedExport(t *testing.T) {
+	logger := gologger.NewNullLogger()
+
+	oneSignalAppHttpClient := NewQueueResponseAppHttpClient()
+	oneSignalAppHttpClient.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("{\"success\":true}")),
+	})
+
+	cleaner := NewCleaner("app-id", "rest-api-key", logger)
+	cleaner.OneSignalClient.AppHttpClient = oneSignalAppHttpClient
+	cleaner.Downloader.AppHttpClient = NewQueueResponseAppHttpClient()
+	cleaner.TmpDir = t.TempDir()
+	cleaner.MaxExportAge = 3600
+
+	data, err := ioutil.ReadFile("gz_csv_reader_test_data.csv.gz")
+	assert.NoError(t, err)
+	cachedFileName := cleaner.ExportCache().FileName(cleaner.Now() - 60)
+	assert.NoError(t, ioutil.WriteFile(cachedFileName, data, 0644))
+
+	var readFileName string
+	cleaner.GzCsvReaderFactory = func(filename string) (*GzCsvReader, error) {
+		readFileName = filename
+		return NewGzCsvReader(filename)
+	}
 
+	err = cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, cachedFileName, readFileName)
+	assert.Equal(t, 0, oneSignalAppHttpClient.Size())
+}
+
+func TestCleaner_Clean_MinFreeDiskSpace(t *testing.T) {
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.AppHttpClient = NewQueueResponseAppHttpClient()
+	cleaner.TmpDir = t.TempDir()
+	cleaner.MinFreeDiskSpace = math.MaxUint64
 	err := cleaner.Clean()
+	assert.Error(t, err)
+}
+
+func TestCleaner_Clean_Stream(t *testing.T) {
+	logger := gologger.NewNullLogger()
+
+	oneSignalAppHttpClient := NewQueueResponseAppHttpClient()
+	oneSignalAppHttpClient.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("{ \"csv_file_url\": \"https://onesignal.com/csv_exports/app-id/users.csv.gz\" }")),
+	})
+	oneSignalAppHttpClient.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("{\"success\":true}")),
+	})
+
+	data, err := ioutil.ReadFile("gz_csv_reader_test_data.csv.gz")
 	assert.NoError(t, err)
+	downloaderAppHttpClient := NewQueueResponseAppHttpClient()
+	downloaderAppHttpClient.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBuffer(data)),
+		Header: map[string][]string{
+			"Content-Length": {strconv.Itoa(len(data))},
+		},
+	})
+
+	cleaner := NewCleaner("app-id", "rest-api-key", logger)
+	cleaner.OneSignalClient.AppHttpClient = oneSignalAppHttpClient
+	cleaner.Downloader.AppHttpClient = downloaderAppHttpClient
+	cleaner.Downloader.Logger = logger
+	cleaner.TmpDir = t.TempDir()
+	cleaner.Stream = true
+	cleaner.StreamArchive = true
 
+	err = cleaner.Clean()
+	assert.NoError(t, err)
 	assert.Equal(t, 0, oneSignalAppHttpClient.Size())
+	assert.Equal(t, 0, downloaderAppHttpClient.Size())
+
+	archived, err := ioutil.ReadFile(cleaner.getDestFileName())
+	assert.NoError(t, err)
+	assert.Equal(t, data, archived)
+}
+
+func TestCleaner_Clean_CorruptedDataFile(t *testing.T) {
+	logger := gologger.NewNullLogger()
+	data, err := ioutil.ReadFile("gz_csv_reader_test_data.csv.gz")
+	assert.NoError(t, err)
+
+	for _, body := range [][]byte{[]byte("foobar"), data[:len(data)-4]} {
+		oneSignalAppHttpClient := NewQueueResponseAppHttpClient()
+		oneSignalAppHttpClient.Enqueue(&http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("{ \"csv_file_url\": \"https://onesignal.com/csv_exports/app-id/users.csv.gz\" }")),
+		})
+		downloaderAppHttpClient := NewQueueResponseAppHttpClient()
+		downloaderAppHttpClient.Enqueue(&http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+			Header: map[string][]string{
+				"Content-Length": {strconv.Itoa(len(body))},
+			},
+		})
+
+		cleaner := NewCleaner("app-id", "rest-api-key", logger)
+		cleaner.OneSignalClient.AppHttpClient = oneSignalAppHttpClient
+		cleaner.Downloader.AppHttpClient = downloaderAppHttpClient
+		cleaner.Downloader.Logger = logger
+		cleaner.TmpDir = t.TempDir()
+
+		err = cleaner.Clean()
+		assert.Error(t, err)
+		exports, err := cleaner.ExportCache().List()
+		assert.NoError(t, err)
+		assert.Empty(t, exports)
+	}
+}
+
+type memoryArchiveSink struct {
+	objects map[string][]byte
+}
+
+func (s *memoryArchiveSink) UploadFile(key string, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memoryArchiveSink) Upload(key string, data []byte, contentType string) error {
+	s.objects[key] = data
+	return nil
+}
+
+func TestCleaner_Clean_Sink(t *testing.T) {
+	logger := gologger.NewNullLogger()
+
+	oneSignalAppHttpClient := NewQueueResponseAppHttpClient()
+	oneSignalAppHttpClient.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("{ \"csv_file_url\": \"https://onesignal.com/csv_exports/app-id/users.csv.gz\" }")),
+	})
+	oneSignalAppHttpClient.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("{\"success\":true}")),
+	})
+	data, err := ioutil.ReadFile("gz_csv_reader_test_data.csv.gz")
+	assert.NoError(t, err)
+	downloaderAppHttpClient := NewQueueResponseAppHttpClient()
+	downloaderAppHttpClient.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBuffer(data)),
+		Header: map[string][]string{
+			"Content-Length": {strconv.Itoa(len(data))},
+		},
+	})
+
+	sink := &memoryArchiveSink{objects: map[string][]byte{}}
+	cleaner := NewCleaner("app-id", "rest-api-key", logger)
+	cleaner.OneSignalClient.AppHttpClient = oneSignalAppHttpClient
+	cleaner.Downloader.AppHttpClient = downloaderAppHttpClient
+	cleaner.TmpDir = t.TempDir()
+	cleaner.Sink = sink
+	cleaner.Now = func() int {
+		return 1600000000
+	}
+
+	err = cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, data, sink.objects["app-id/onesignal-players-app-id-20200913122640.csv.gz"])
+	var report RunReport
+	assert.NoError(t, json.Unmarshal(sink.objects["app-id/onesignal-cleaner-report-app-id-20200913122640.json"], &report))
+	assert.True(t, report.Success)
+	assert.Equal(t, int64(2), report.Rows)
+	assert.Equal(t, int64(1), report.Deleted)
+}
+
+func TestCleaner_Clean_InvalidHeader(t *testing.T) {
+	fileName := t.TempDir() + "/data.csv.gz"
+	writeGzCsv(t, fileName, [][]string{
+		{"id", "last_seen"},
+		{"id1", "1970-10-26 08:48:42"},
+	})
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.AppHttpClient = NewQueueResponseAppHttpClient()
+	err := cleaner.Clean(fileName)
+	assert.EqualError(t, err, "invalid data file header, missing columns: last_active; unexpected columns: last_seen")
+}
+
+func TestCleaner_isInactive(t *testing.T) {
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.InactiveFor = 3600
+	cleaner.Now = func() int {
+		return 1600000000
+	}
+	inactive, err := cleaner.isInactive(Player{LastActive: time.Unix(1600000000-3600, 0)})
+	assert.NoError(t, err)
+	assert.True(t, inactive)
+	inactive, err = cleaner.isInactive(Player{LastActive: time.Unix(1600000000-60, 0)})
+	assert.NoError(t, err)
+	assert.False(t, inactive)
+
+	future := Player{LastActive: time.Unix(1600000000+60, 0)}
+	inactive, err = cleaner.isInactive(future)
+	assert.NoError(t, err)
+	assert.False(t, inactive)
+	cleaner.FutureLastActive = FutureLastActiveInactive
+	inactive, err = cleaner.isInactive(future)
+	assert.NoError(t, err)
+	assert.True(t, inactive)
+	cleaner.FutureLastActive = FutureLastActiveSkip
+	_, err = cleaner.isInactive(future)
+	assert.Error(t, err)
+}
+
+func TestCleaner_Clean_FakeOneSignal(t *testing.T) {
+	now := time.Now()
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	server.AddPlayer("inactive-1", now.AddDate(-1, 0, 0))
+	server.AddPlayer("active", now.Add(-time.Hour))
+	server.AddPlayer("inactive-2", now.AddDate(-2, 0, 0))
+	server.AddPlayer("inactive-3", now.AddDate(-3, 0, 0))
+	server.NotReadyResponses = 2
+	server.InjectFault(http.MethodGet, "/csv_exports/", fakeonesignal.Fault{Truncate: true, Times: 1})
+	server.InjectFault(http.MethodDelete, "/api/v1/players/inactive-3", fakeonesignal.Fault{Status: http.StatusInternalServerError})
+	server.Start()
+	defer server.Close()
+
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.OriginUrl = server.URL()
+	cleaner.Downloader.Pause = 10 * time.Millisecond
+	cleaner.TmpDir = t.TempDir()
+	cleaner.InactiveFor = 86400 * 30
+	cleaner.Concurrency = 2
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"inactive-1", "inactive-2"}, server.Deleted())
+	assert.Equal(t, []string{"active", "inactive-3"}, server.Players())
+}
+
+func TestCleaner_Clean_VerifyDeletions(t *testing.T) {
+	now := time.Now()
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	server.AddPlayer("deleted", now.AddDate(-1, 0, 0))
+	server.AddPlayer("ghost", now.AddDate(-1, 0, 0))
+	server.AddPlayer("active", now)
+	server.InjectFault(http.MethodDelete, "/api/v1/players/ghost", fakeonesignal.Fault{Ignore: true, Times: 1})
+	server.Start()
+	defer server.Close()
+
+	sink := &memoryArchiveSink{objects: map[string][]byte{}}
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.OriginUrl = server.URL()
+	cleaner.Downloader.Pause = 10 * time.Millisecond
+	cleaner.TmpDir = t.TempDir()
+	cleaner.InactiveFor = 86400 * 30
+	cleaner.Sink = sink
+	cleaner.VerifyDeletions = 1
+	cleaner.VerifyDeletionsDelay = 0
+	cleaner.RetryUndeleted = true
+	journalFilename := t.TempDir() + "/journal.csv"
+	journal, err := OpenDeletionJournal(journalFilename)
+	assert.NoError(t, err)
+	cleaner.Journal = journal
+
+	err = cleaner.Clean()
+	assert.NoError(t, err)
+	assert.NoError(t, journal.Close())
+	assert.Equal(t, []string{"active"}, server.Players())
+	journalData, err := ioutil.ReadFile(journalFilename)
+	assert.NoError(t, err)
+	// The header and the deleted players, the retried deletion is not recorded again
+	assert.Equal(t, 3, strings.Count(string(journalData), "\n"))
+	assert.Empty(t, cleaner.deletions.take())
+
+	var report RunReport
+	for key, data := range sink.objects {
+		if strings.HasPrefix(key, "app-id/onesignal-cleaner-report-") {
+			assert.NoError(t, json.Unmarshal(data, &report))
+		}
+	}
+	assert.Equal(t, 1, report.Verified)
+	assert.Equal(t, []string{"ghost"}, report.NotDeleted)
+	assert.Equal(t, 1, report.Redeleted)
+	assert.Equal(t, 0, report.VerificationErrors)
+}
+
+func TestCleaner_Clean_VerifyDeletions_FailedRun(t *testing.T) {
+	yearAgo := time.Now().AddDate(-1, 0, 0)
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	server.AddPlayer("inactive", yearAgo)
+	server.Start()
+	defer server.Close()
+
+	filename := t.TempDir() + "/export.csv"
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("id,last_active\ninactive,"+yearAgo.UTC().Format(OneSignalTimeLayout)+"\nbroken,\"\n"), 0644))
+
+	sink := &memoryArchiveSink{objects: map[string][]byte{}}
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.OriginUrl = server.URL()
+	cleaner.InactiveFor = 86400 * 30
+	cleaner.CsvOptions.Strict = true
+	cleaner.Sink = sink
+	cleaner.VerifyDeletions = 1
+	cleaner.VerifyDeletionsDelay = 0
+
+	assert.Error(t, cleaner.Clean(filename))
+	var report RunReport
+	for key, data := range sink.objects {
+		if strings.HasPrefix(key, "app-id/onesignal-cleaner-report-") {
+			assert.NoError(t, json.Unmarshal(data, &report))
+		}
+	}
+	assert.False(t, report.Success)
+	assert.Equal(t, 1, report.Verified)
+}
+
+func TestCleaner_Clean_CheckFreshness(t *testing.T) {
+	now := time.Now()
+	yearAgo := now.AddDate(-1, 0, 0)
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	server.AddPlayer("inactive", yearAgo)
+	server.AddPlayer("revived", now.Add(-time.Minute))
+	server.Start()
+	defer server.Close()
+
+	filename := t.TempDir() + "/export.csv.gz"
+	writeGzCsv(t, filename, [][]string{
+		{"id", "last_active"},
+		{"inactive", yearAgo.UTC().Format(OneSignalTimeLayout)},
+		{"revived", yearAgo.UTC().Format(OneSignalTimeLayout)},
+		{"gone", yearAgo.UTC().Format(OneSignalTimeLayout)},
+	})
+
+	for _, workers := range []int{0, 2} {
+		cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+		cleaner.OneSignalClient.OriginUrl = server.URL()
+		cleaner.InactiveFor = 86400 * 30
+		cleaner.ParseWorkers = workers
+		cleaner.CheckFreshness = true
+		r, err := cleaner.GzCsvReaderFactory(filename)
+		assert.NoError(t, err)
+		stats, err := cleaner.handlePlayers(r)
+		r.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), stats.Inactive)
+		assert.Equal(t, int64(2), stats.Skipped)
+		assert.Equal(t, int64(0), stats.Errors)
+		assert.Equal(t, []string{"revived"}, server.Players())
+		server.AddPlayer("inactive", yearAgo)
+	}
+	assert.Equal(t, []string{"inactive", "inactive"}, server.Deleted())
+}
+
+func TestCleaner_isStillInactive_PolicyError(t *testing.T) {
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	server.AddPlayer("future", time.Now().AddDate(1, 0, 0))
+	server.Start()
+	defer server.Close()
+
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.OriginUrl = server.URL()
+	cleaner.FutureLastActive = FutureLastActiveSkip
+	stats := NewProcessingProgress()
+	cleaner.CheckFreshness = true
+	cleaner.handleInactivePlayer(Player{Id: "future", LastActive: time.Now().AddDate(-1, 0, 0)}, stats)
+	assert.Equal(t, int64(1), stats.Snapshot().Errors)
+	assert.Equal(t, int64(0), stats.Snapshot().Skipped)
+	assert.Empty(t, server.Deleted())
+}
+
+func TestCleaner_isStillInactive_UnknownLastActive(t *testing.T) {
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	client := NewQueueResponseAppHttpClient()
+	// Nothing but the player fetching is enqueued, so a deletion request panics
+	client.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"id":"id1"}`)),
+	})
+	cleaner.OneSignalClient.AppHttpClient = client
+	cleaner.CheckFreshness = true
+	stats := NewProcessingProgress()
+	cleaner.handleInactivePlayer(Player{Id: "id1", LastActive: time.Now().AddDate(-1, 0, 0)}, stats)
+	assert.Equal(t, int64(1), stats.Snapshot().Skipped)
+	assert.Equal(t, int64(0), stats.Snapshot().Deleted)
+	assert.Equal(t, int64(0), stats.Snapshot().Errors)
 }
 
+func TestCleaner_Clean_SoftDelete(t *testing.T) {
+	now := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	yearAgo := now.AddDate(-1, 0, 0).Format(OneSignalTimeLayout)
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	for _, id := range []string{"new-stale", "stale", "expired"} {
+		server.AddPlayer(id, now.AddDate(-1, 0, 0))
+	}
+	server.Start()
+	defer server.Close()
+
+	filename := t.TempDir() + "/export.csv.gz"
+	writeGzCsv(t, filename, [][]string{
+		{"id", "last_active", "tags"},
+		{"new-stale", yearAgo, `{"level":"5"}`},
+		{"stale", yearAgo, `{"stale_since":"2022-02-20"}`},
+		{"expired", yearAgo, `{"stale_since":"2022-01-01"}`},
+	})
 
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.OriginUrl = server.URL()
+	cleaner.InactiveFor = 86400 * 30
+	cleaner.Action = ActionModeUnsubscribe
+	cleaner.GracePeriod = 86400 * 30
+	cleaner.Now = func() int {
+		return int(now.Unix())
+	}
+	r, err := cleaner.GzCsvReaderFactory(filename)
+	assert.NoError(t, err)
+	defer r.Close()
+	stats, err := cleaner.handlePlayers(r)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Inactive)
+	assert.Equal(t, int64(1), stats.Edited)
+	assert.Equal(t, int64(1), stats.Skipped)
+	assert.Equal(t, int64(1), stats.Deleted)
+	assert.Equal(t, []string{"expired"}, server.Deleted())
+	assert.Equal(t, "-2", server.Player("new-stale")["notification_types"])
+	assert.Equal(t, `{"stale_since":"2022-03-01"}`, server.Player("new-stale")["tags"])
+	assert.Equal(t, "1", server.Player("stale")["notification_types"])
+}
+
+func TestCleaner_Clean_SoftDelete_ActiveAgain(t *testing.T) {
+	now := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	server.AddPlayer("active-again", now.AddDate(0, -6, 0))
+	server.Start()
+	defer server.Close()
+
+	// The player has been marked long ago, then it has been active and it is inactive again
+	filename := t.TempDir() + "/export.csv.gz"
+	writeGzCsv(t, filename, [][]string{
+		{"id", "last_active", "tags"},
+		{"active-again", now.AddDate(0, -6, 0).Format(OneSignalTimeLayout), `{"stale_since":"2021-01-01"}`},
+	})
+
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.OriginUrl = server.URL()
+	cleaner.InactiveFor = 86400 * 30
+	cleaner.Action = ActionModeTag
+	cleaner.GracePeriod = 86400 * 30
+	cleaner.Now = func() int {
+		return int(now.Unix())
+	}
+	r, err := cleaner.GzCsvReaderFactory(filename)
+	assert.NoError(t, err)
+	defer r.Close()
+	stats, err := cleaner.handlePlayers(r)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Edited)
+	assert.Equal(t, int64(0), stats.Deleted)
+	assert.Empty(t, server.Deleted())
+	assert.Equal(t, `{"stale_since":"2022-03-01"}`, server.Player("active-again")["tags"])
+}
+
+func TestCleaner_Clean_SoftDelete_NoTags(t *testing.T) {
+	yearAgo := time.Now().AddDate(-1, 0, 0).UTC().Format(OneSignalTimeLayout)
+	filename := t.TempDir() + "/export.csv.gz"
+	writeGzCsv(t, filename, [][]string{
+		{"id", "last_active"},
+		{"id1", yearAgo},
+	})
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.AppHttpClient = NewQueueResponseAppHttpClient()
+	cleaner.Action = ActionModeTag
+	r, err := cleaner.GzCsvReaderFactory(filename)
+	assert.NoError(t, err)
+	defer r.Close()
+	_, err = cleaner.handlePlayers(r)
+	assert.Error(t, err)
+
+	// Rows without a header (e.g. of View devices API) are checked one by one
+	stats := NewProcessingProgress()
+	cleaner.handleInactivePlayer(Player{Id: "id1", Data: PlayerData{"id": "id1"}}, stats)
+	assert.Equal(t, int64(1), stats.Snapshot().Errors)
+	assert.Equal(t, int64(0), stats.Snapshot().Edited)
+}
+
+func TestParseActionMode(t *testing.T) {
+	mode, err := ParseActionMode("TAG")
+	assert.NoError(t, err)
+	assert.Equal(t, ActionModeTag, mode)
+	mode, err = ParseActionMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, ActionModeDelete, mode)
+	_, err = ParseActionMode("archive")
+	assert.Error(t, err)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+type Compression string
+
+const (
+	CompressionNone  Compression = "none"
+	CompressionGzip  Compression = "gzip"
+	CompressionZstd  Compression = "zstd"
+	CompressionBzip2 Compression = "bzip2"
+)
+
+var compressionMagicBytes = []struct {
+	compression Compression
+	magic       []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CompressionBzip2, []byte("BZh")},
+}
+
+// DetectCompression detects a compression by magic bytes without consuming them
+func DetectCompression(r *bufio.Reader) (Compression, error) {
+	head, err := r.Peek(4)
+	if err != nil && err != io.EOF {
+		return "", errors.Wrap(err, "error while reading magic bytes")
+	}
+	for _, m := range compressionMagicBytes {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.compression, nil
+		}
+	}
+	return CompressionNone, nil
+}
+
+// newDecompressor returns a reader of decompressed data auto-detecting a compression of r
+func newDecompressor(r io.Reader) (io.ReadCloser, Compression, error) {
+	br := bufio.NewReader(r)
+	compression, err := DetectCompression(br)
+	if err != nil {
+		return nil, "", err
+	}
+	switch compression {
+	case CompressionGzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, compression, errors.Wrap(err, "error while creating a new gzip reader")
+		}
+		return gr, compression, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, compression, errors.Wrap(err, "error while creating a new zstd reader")
+		}
+		return zr.IOReadCloser(), compression, nil
+	case CompressionBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(br)), compression, nil
+	default:
+		return ioutil.NopCloser(br), compression, nil
+	}
+}
+
+// DetectFileCompression detects a compression of the file by magic bytes
+func DetectFileCompression(filename string) (Compression, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Wrap(err, "error while opening a data file")
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	return DetectCompression(bufio.NewReader(f))
+}
+
+// VerifyDataFile reads the whole file checking its integrity (e.g. gzip CRC-32 and size)
+func VerifyDataFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "error while opening a data file")
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	r, compression, err := newDecompressor(f)
+	if err != nil {
+		return err
+	}
+	defer func(r io.ReadCloser) {
+		_ = r.Close()
+	}(r)
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return errors.Wrapf(err, "data file is corrupted (%s)", compression)
+	}
+	return nil
+}
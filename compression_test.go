@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDetectCompression(t *testing.T) {
+	for fileName, expected := range map[string]Compression{
+		"gz_csv_reader_test_data.csv":     CompressionNone,
+		"gz_csv_reader_test_data.csv.gz":  CompressionGzip,
+		"gz_csv_reader_test_data.csv.bz2": CompressionBzip2,
+	} {
+		data, err := ioutil.ReadFile(fileName)
+		assert.NoError(t, err)
+		compression, err := DetectCompression(bufio.NewReader(bytes.NewReader(data)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, compression, fileName)
+	}
+	compression, err := DetectCompression(bufio.NewReader(bytes.NewReader(nil)))
+	assert.NoError(t, err)
+	assert.Equal(t, CompressionNone, compression)
+}
+
+func TestGzCsvReader_Compressions(t *testing.T) {
+	plain, err := ioutil.ReadFile("gz_csv_reader_test_data.csv")
+	assert.NoError(t, err)
+	zw, err := zstd.NewWriter(nil)
+	assert.NoError(t, err)
+	zstdFileName := t.TempDir() + "/data.csv.zst"
+	assert.NoError(t, ioutil.WriteFile(zstdFileName, zw.EncodeAll(plain, nil), 0644))
+	for _, fileName := range []string{
+		"gz_csv_reader_test_data.csv",
+		"gz_csv_reader_test_data.csv.gz",
+		"gz_csv_reader_test_data.csv.bz2",
+		zstdFileName,
+	} {
+		reader, err := NewGzCsvReader(fileName)
+		assert.NoError(t, err, fileName)
+		l1, err := reader.ReadLine()
+		assert.NoError(t, err)
+		assert.Equal(t, "id1", l1["id"], fileName)
+		l2, err := reader.ReadLine()
+		assert.NoError(t, err)
+		assert.Equal(t, "id2", l2["id"], fileName)
+		_, err = reader.ReadLine()
+		assert.ErrorIs(t, err, io.EOF)
+		reader.Close()
+		assert.NoError(t, VerifyDataFile(fileName), fileName)
+	}
+}
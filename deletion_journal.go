@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var deletionJournalHeader = []string{"id", "last_active", "deleted_at"}
+
+// DeletionJournal appends successfully deleted players to a CSV file, it is safe for concurrent use
+type DeletionJournal struct {
+	Filename string
+	Now      Nower
+	mu       sync.Mutex
+	f        *os.File
+	w        *csv.Writer
+}
+
+// OpenDeletionJournal opens the journal for appending, the header is written to a new (empty) file only
+func OpenDeletionJournal(filename string) (*DeletionJournal, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while opening a deletion journal: %s", filename)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "error while getting a deletion journal stats: %s", filename)
+	}
+	j := &DeletionJournal{
+		Filename: filename,
+		Now:      Now,
+		f:        f,
+		w:        csv.NewWriter(f),
+	}
+	if stat.Size() == 0 {
+		if err := j.write(deletionJournalHeader); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// Record is no-op for nil journal
+func (j *DeletionJournal) Record(p Player) error {
+	if j == nil {
+		return nil
+	}
+	return j.write([]string{p.Id, p.LastActive.UTC().Format(OneSignalTimeLayout), strconv.Itoa(j.Now())})
+}
+
+func (j *DeletionJournal) write(record []string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// Every record is flushed, so the journal is complete even if the process is killed
+	_ = j.w.Write(record)
+	j.w.Flush()
+	if err := j.w.Error(); err != nil {
+		return errors.Wrapf(err, "error while writing a deletion journal: %s", j.Filename)
+	}
+	return nil
+}
+
+func (j *DeletionJournal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+// DeletedPlayer is a record of a deletion journal, LastActive and DeletedAt are zero if unknown
+type DeletedPlayer struct {
+	Id         string
+	LastActive time.Time
+	DeletedAt  time.Time
+}
+
+// ReadDeletedPlayers reads a deletion journal or a plain list of player IDs (one per line),
+// the file can be compressed.
+func ReadDeletedPlayers(filename string) (map[string]DeletedPlayer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while opening deleted players file: %s", filename)
+	}
+	defer f.Close()
+	dr, _, err := newDecompressor(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while decompressing deleted players file: %s", filename)
+	}
+	defer dr.Close()
+	br := bufio.NewReader(dr)
+	head, _ := br.Peek(len("id,"))
+	deleted := map[string]DeletedPlayer{}
+	if string(head) != "id," {
+		for {
+			line, err := br.ReadString('\n')
+			if id := strings.TrimSpace(line); id != "" && id != "id" {
+				deleted[id] = DeletedPlayer{Id: id}
+			}
+			if err == io.EOF {
+				return deleted, nil
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "error while reading deleted players file: %s", filename)
+			}
+		}
+	}
+	r := csv.NewReader(br)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while reading deleted players file header: %s", filename)
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return deleted, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error while reading deleted players file: %s", filename)
+		}
+		p := DeletedPlayer{}
+		for i, column := range header {
+			if i >= len(record) {
+				break
+			}
+			switch column {
+			case "id":
+				p.Id = record[i]
+			case "last_active":
+				p.LastActive, _ = time.Parse(OneSignalTimeLayout, record[i])
+			case "deleted_at":
+				if ts, err := strconv.ParseInt(record[i], 10, 64); err == nil {
+					p.DeletedAt = time.Unix(ts, 0).UTC()
+				}
+			}
+		}
+		if p.Id != "" {
+			deleted[p.Id] = p
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestDeletionJournal(t *testing.T) {
+	filename := t.TempDir() + "/journal.csv"
+	lastActive := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, id := range []string{"id1", "id2"} {
+		j, err := OpenDeletionJournal(filename)
+		assert.NoError(t, err)
+		j.Now = func() int {
+			return 1600000000
+		}
+		assert.NoError(t, j.Record(Player{Id: id, LastActive: lastActive}))
+		assert.NoError(t, j.Close())
+	}
+	data, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "id,last_active,deleted_at\nid1,2020-01-01 00:00:00,1600000000\nid2,2020-01-01 00:00:00,1600000000\n", string(data))
+
+	deleted, err := ReadDeletedPlayers(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]DeletedPlayer{
+		"id1": {Id: "id1", LastActive: lastActive, DeletedAt: time.Unix(1600000000, 0).UTC()},
+		"id2": {Id: "id2", LastActive: lastActive, DeletedAt: time.Unix(1600000000, 0).UTC()},
+	}, deleted)
+
+	var nilJournal *DeletionJournal
+	assert.NoError(t, nilJournal.Record(Player{Id: "id3"}))
+}
+
+func TestReadDeletedPlayers_IdPerLine(t *testing.T) {
+	filename := t.TempDir() + "/deleted.txt"
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("id1\n\n id2 \nid3"), 0644))
+	deleted, err := ReadDeletedPlayers(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]DeletedPlayer{
+		"id1": {Id: "id1"},
+		"id2": {Id: "id2"},
+		"id3": {Id: "id3"},
+	}, deleted)
+}
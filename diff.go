@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+type LastActiveChange struct {
+	Id     string    `json:"id"`
+	Before time.Time `json:"before"`
+	After  time.Time `json:"after"`
+}
+
+// ExportDiff is a difference between two exports by player ID
+type ExportDiff struct {
+	OldRows   int                `json:"old_rows"`
+	NewRows   int                `json:"new_rows"`
+	Errors    int                `json:"errors"`
+	Added     []string           `json:"added"`
+	Removed   []string           `json:"removed"`
+	Changed   []LastActiveChange `json:"changed"`
+	Unchanged int                `json:"unchanged"`
+	// ConfirmedDeletions is a number of deleted players (according to a deletion journal) of the old export
+	// missing in the new export
+	ConfirmedDeletions int `json:"confirmed_deletions"`
+	// NotDeleted are deleted players (according to a deletion journal) still present in the new export
+	NotDeleted []string `json:"not_deleted"`
+}
+
+// Diff compares the exports by player ID, deleted players are cross-checked against the new export,
+// player IDs and last_active of the old export are kept in memory. Only deleted players of the old export
+// deleted before newExportedAt (zero if unknown) are cross-checked, later deletions can not be in the new export yet.
+func (c *Cleaner) Diff(oldInput string, newInput string, deleted map[string]DeletedPlayer, newExportedAt time.Time) (*ExportDiff, error) {
+	diff := &ExportDiff{
+		Added:      []string{},
+		Removed:    []string{},
+		Changed:    []LastActiveChange{},
+		NotDeleted: []string{},
+	}
+	old := map[string]time.Time{}
+	err := c.readPlayers(oldInput, func(p Player) {
+		diff.OldRows++
+		old[p.Id] = p.LastActive
+	}, &diff.Errors)
+	if err != nil {
+		return nil, err
+	}
+	checked := map[string]bool{}
+	for id, p := range deleted {
+		if _, ok := old[id]; !ok {
+			continue
+		}
+		// DeletedAt is zero for plain lists of player IDs
+		if !newExportedAt.IsZero() && !p.DeletedAt.IsZero() && !p.DeletedAt.Before(newExportedAt) {
+			continue
+		}
+		checked[id] = true
+	}
+	seen := map[string]bool{}
+	err = c.readPlayers(newInput, func(p Player) {
+		diff.NewRows++
+		seen[p.Id] = true
+		if checked[p.Id] {
+			diff.NotDeleted = append(diff.NotDeleted, p.Id)
+		}
+		before, ok := old[p.Id]
+		if !ok {
+			diff.Added = append(diff.Added, p.Id)
+			return
+		}
+		if before.Equal(p.LastActive) {
+			diff.Unchanged++
+		} else {
+			diff.Changed = append(diff.Changed, LastActiveChange{Id: p.Id, Before: before, After: p.LastActive})
+		}
+	}, &diff.Errors)
+	if err != nil {
+		return nil, err
+	}
+	for id := range old {
+		if !seen[id] {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	for id := range checked {
+		if !seen[id] {
+			diff.ConfirmedDeletions++
+		}
+	}
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.NotDeleted)
+	return diff, nil
+}
+
+// readPlayers calls handle for every valid player of the input, invalid rows are counted in errorsCount
+func (c *Cleaner) readPlayers(input string, handle func(p Player), errorsCount *int) error {
+	r, err := c.openInput(input)
+	if err != nil {
+		return errors.Wrapf(err, "error while opening an input: %s", input)
+	}
+	defer r.Close()
+	if hr, ok := r.(headerReader); ok {
+		if err := c.validateHeader(hr.Header()); err != nil {
+			return errors.Wrapf(err, "error while validating an input: %s", input)
+		}
+	}
+	for i := 1; ; i++ {
+		pd, err := r.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading line #%d of %s", i, input)
+		}
+		p, err := c.unmarshalPlayerData(pd)
+		if err != nil {
+			*errorsCount++
+			c.Logger.
+				WithField("input", input).
+				WithField("id", pd["id"]).
+				WithError(err).
+				Warningf("Error while unmarshalling a player data")
+			continue
+		}
+		handle(p)
+	}
+}
+
+func WriteDiff(w io.Writer, d *ExportDiff, format AnalysisFormat) error {
+	switch format {
+	case AnalysisFormatJson:
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(d)
+	case AnalysisFormatCsv:
+		return writeDiffCsv(w, d)
+	default:
+		return writeDiffText(w, d)
+	}
+}
+
+func writeDiffCsv(w io.Writer, d *ExportDiff) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"change", "id", "last_active_before", "last_active_after"})
+	for _, id := range d.Added {
+		_ = cw.Write([]string{"added", id, "", ""})
+	}
+	for _, id := range d.Removed {
+		_ = cw.Write([]string{"removed", id, "", ""})
+	}
+	for _, change := range d.Changed {
+		_ = cw.Write([]string{
+			"changed",
+			change.Id,
+			change.Before.UTC().Format(OneSignalTimeLayout),
+			change.After.UTC().Format(OneSignalTimeLayout),
+		})
+	}
+	for _, id := range d.NotDeleted {
+		_ = cw.Write([]string{"not_deleted", id, "", ""})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeDiffText(w io.Writer, d *ExportDiff) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Old export players:\t%d\t\n", d.OldRows)
+	_, _ = fmt.Fprintf(tw, "New export players:\t%d\t\n", d.NewRows)
+	_, _ = fmt.Fprintf(tw, "Errors:\t%d\t\n", d.Errors)
+	_, _ = fmt.Fprintf(tw, "Added:\t%d\t\n", len(d.Added))
+	_, _ = fmt.Fprintf(tw, "Removed:\t%d\t\n", len(d.Removed))
+	_, _ = fmt.Fprintf(tw, "Changed last_active:\t%d\t\n", len(d.Changed))
+	_, _ = fmt.Fprintf(tw, "Unchanged:\t%d\t\n", d.Unchanged)
+	_, _ = fmt.Fprintf(tw, "Confirmed deletions:\t%d\t\n", d.ConfirmedDeletions)
+	_, _ = fmt.Fprintf(tw, "Deletions not taken effect:\t%d\t\n", len(d.NotDeleted))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, id := range d.NotDeleted {
+		if _, err := fmt.Fprintf(w, "not deleted: %s\n", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"github.com/mingalevme/gologger"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCleaner_Diff(t *testing.T) {
+	dir := t.TempDir()
+	writeGzCsv(t, dir+"/old.csv.gz", [][]string{
+		{"id", "last_active"},
+		{"kept", "2020-01-01 00:00:00"},
+		{"changed", "2020-01-01 00:00:00"},
+		{"deleted", "2019-01-01 00:00:00"},
+		{"not-deleted", "2019-01-01 00:00:00"},
+		{"removed", "2021-01-01 00:00:00"},
+		{"deleted-later", "2019-01-01 00:00:00"},
+	})
+	writeGzCsv(t, dir+"/new.csv.gz", [][]string{
+		{"id", "last_active"},
+		{"kept", "2020-01-01 00:00:00"},
+		{"changed", "2021-06-01 00:00:00"},
+		{"not-deleted", "2019-01-01 00:00:00"},
+		{"added", "2021-07-01 00:00:00"},
+		{"deleted-later", "2019-01-01 00:00:00"},
+		{"", "2021-07-01 00:00:00"},
+	})
+	newExportedAt := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+	cleaner := NewCleaner("", "", gologger.NewNullLogger())
+	diff, err := cleaner.Diff(dir+"/old.csv.gz", dir+"/new.csv.gz", map[string]DeletedPlayer{
+		"deleted":     {Id: "deleted", DeletedAt: newExportedAt.Add(-time.Hour)},
+		"not-deleted": {Id: "not-deleted"},
+		// Deleted after the new export has been taken, so it is still there
+		"deleted-later": {Id: "deleted-later", DeletedAt: newExportedAt.Add(time.Hour)},
+		// Not in the old export, so it is not a confirmed deletion
+		"never-exported": {Id: "never-exported", DeletedAt: newExportedAt.Add(-time.Hour)},
+	}, newExportedAt)
+	assert.NoError(t, err)
+	assert.Equal(t, &ExportDiff{
+		OldRows: 6,
+		NewRows: 5,
+		Errors:  1,
+		Added:   []string{"added"},
+		Removed: []string{"deleted", "removed"},
+		Changed: []LastActiveChange{{
+			Id:     "changed",
+			Before: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			After:  time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		Unchanged:          3,
+		ConfirmedDeletions: 1,
+		NotDeleted:         []string{"not-deleted"},
+	}, diff)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, WriteDiff(buf, diff, AnalysisFormatCsv))
+	assert.Equal(t, "change,id,last_active_before,last_active_after\n"+
+		"added,added,,\n"+
+		"removed,deleted,,\n"+
+		"removed,removed,,\n"+
+		"changed,changed,2020-01-01 00:00:00,2021-06-01 00:00:00\n"+
+		"not_deleted,not-deleted,,\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, WriteDiff(buf, diff, AnalysisFormatText))
+	assert.Contains(t, buf.String(), "not deleted: not-deleted\n")
+}
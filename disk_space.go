@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || dragonfly
+// +build linux darwin freebsd dragonfly
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"syscall"
+)
+
+func getFreeDiskSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, errors.Wrapf(err, "error while getting file system stats: %s", dir)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
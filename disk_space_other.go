@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!dragonfly
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"runtime"
+)
+
+func getFreeDiskSpace(dir string) (uint64, error) {
+	return 0, errors.Errorf("getting free disk space is not supported on %s", runtime.GOOS)
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"github.com/mingalevme/gologger"
 	"github.com/pkg/errors"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,6 +16,13 @@ type Downloader struct {
 	AppHttpClient    AppHttpClient
 	ReadinessTimeout int
 	Pause            time.Duration
+	// Polling overrides fixed Pause between readiness checks if set
+	Polling          PollingStrategy
+	// NotReadyStatuses are response status codes meaning the data is not ready yet
+	NotReadyStatuses []int
+	MaxResumes       int
+	// Progress is optional
+	Progress          *ProgressReporter
 	Now               Nower
 	Logger            gologger.Logger
 }
@@ -22,6 +32,8 @@ func NewDownloader() *Downloader {
 		AppHttpClient:    http.DefaultClient,
 		ReadinessTimeout: 600,
 		Pause:            5 * time.Second,
+		NotReadyStatuses: []int{http.StatusForbidden},
+		MaxResumes:       5,
 		Now:              Now,
 		Logger:           gologger.NewStdoutLogger(gologger.LevelInfo),
 	}
@@ -32,39 +44,113 @@ func (d *Downloader) Download(sourceURL string, destination io.Writer) error {
 	if err != nil {
 		return errors.Wrap(err, "error while downloading a data file")
 	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
-	contentLength, err := strconv.Atoi(resp.Header.Get("Content-Length"))
+	contentLength, err := getContentLength(resp)
 	if err != nil {
+		_ = resp.Body.Close()
 		return errors.Wrap(err, "error while getting response Content-Length-header")
 	}
 	d.Logger.
 		WithField("url", sourceURL).
 		WithField("total", contentLength).
 		Infof("Reading response body into a destination ...")
-	wc := &WriteCounter{
-		Total:  contentLength,
-		Logger: d.Logger,
-	}
-	tee := io.TeeReader(resp.Body, wc)
-	n, err := io.Copy(destination, tee)
-	if int(n) != contentLength {
+	wc := NewWriteCounter(contentLength, d.Progress)
+	written := 0
+	resumes := 0
+	for {
+		n, err := io.Copy(destination, io.TeeReader(resp.Body, wc))
+		_ = resp.Body.Close()
+		written += int(n)
+		// The size can be checked only if Content-Length-header is present (e.g. no chunked transfer encoding)
+		if err == nil && (contentLength < 0 || written == contentLength) {
+			break
+		}
+		if err == nil && written > contentLength {
+			return errors.Errorf("invalid number of bytes were written while downloading a remote data: %d of %d", written, contentLength)
+		}
+		if resumes >= d.MaxResumes {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return errors.Wrapf(err, "error while downloading a remote data: %d of %d bytes were written, %d resume(s) made", written, contentLength, resumes)
+		}
+		resumes += 1
 		d.Logger.
 			WithField("url", sourceURL).
-			WithField("io.Copy", n).
-			WithField("WriteCounter", wc.Received).
-			WithField("Content-Length", resp.Header.Get("Content-Length")).
-			Warningf("Invalid number of bytes were written while downloading a remote data")
-		return nil
+			WithField("written", written).
+			WithField("total", contentLength).
+			WithField("resume", resumes).
+			WithError(err).
+			Warningf("Data stream has been broken, resuming downloading ...")
+		time.Sleep(d.Pause)
+		resp, err = d.resume(sourceURL, written)
+		if err != nil {
+			return errors.Wrap(err, "error while resuming downloading a remote data")
+		}
 	}
+	d.Progress.Done("Remote data has been downloaded", wc.State())
 	d.Logger.WithField("url", sourceURL).Infof("Remote resource has been successfully downloaded")
 	return nil
 }
 
+// resume requests the remote data starting from the offset using a Range-header,
+// if the server ignores the header the first offset bytes of the response are skipped.
+func (d *Downloader) resume(sourceURL string, offset int) (*http.Response, error) {
+	req := d.createRequest(sourceURL)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := d.AppHttpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error while requesting a remote data range")
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		contentRange := resp.Header.Get("Content-Range")
+		if !strings.HasPrefix(contentRange, fmt.Sprintf("bytes %d-", offset)) {
+			_ = resp.Body.Close()
+			return nil, errors.Errorf("unexpected Content-Range-header while requesting a remote data from %d: %s", offset, contentRange)
+		}
+		return resp, nil
+	case http.StatusOK:
+		d.Logger.
+			WithField("url", sourceURL).
+			WithField("offset", offset).
+			Warningf("Range-requests are not supported by the server, skipping already downloaded data")
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, int64(offset)); err != nil {
+			_ = resp.Body.Close()
+			return nil, errors.Wrap(err, "error while skipping already downloaded data")
+		}
+		return resp, nil
+	default:
+		_ = resp.Body.Close()
+		return nil, errors.Errorf("unexpected response status code while requesting a remote data range: %d", resp.StatusCode)
+	}
+}
+
+// Open waits for the remote data to be ready and returns its body for streaming, the caller must close it
+func (d *Downloader) Open(sourceURL string) (io.ReadCloser, error) {
+	resp, err := d.request(sourceURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "error while opening a data stream")
+	}
+	contentLength, err := getContentLength(resp)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, errors.Wrap(err, "error while getting response Content-Length-header")
+	}
+	d.Logger.
+		WithField("url", sourceURL).
+		WithField("total", contentLength).
+		Infof("Streaming response body ...")
+	wc := NewWriteCounter(contentLength, d.Progress)
+	return &readCloser{
+		Reader: io.TeeReader(resp.Body, wc),
+		Closer: resp.Body,
+	}, nil
+}
+
 func (d *Downloader) request(sourceURL string) (*http.Response, error) {
 	req := d.createRequest(sourceURL)
 	startedAt := d.Now()
+	waitingSince := time.Now()
 	attempt := 0
 	d.Logger.
 		WithField("url", sourceURL).
@@ -88,12 +174,14 @@ func (d *Downloader) request(sourceURL string) (*http.Response, error) {
 			d.Logger.
 				WithField("url", sourceURL).
 				WithField("attempt", attempt).
+				WithField("waited", time.Since(waitingSince).Round(time.Second).String()).
 				Infof("Data is ready while requesting a remote data")
 			return res, nil
-		} else if res.StatusCode == 403 {
+		} else if d.isNotReadyStatus(res.StatusCode) {
 			d.Logger.
 				WithField("url", sourceURL).
 				WithField("attempt", attempt).
+				WithField("response-status-code", res.StatusCode).
 				Debugf("Data is not ready while requesting a remote data")
 		} else {
 			d.Logger.
@@ -102,21 +190,41 @@ func (d *Downloader) request(sourceURL string) (*http.Response, error) {
 				WithField("response-status-code", res.StatusCode).
 				Errorf("Unexpected response while requesting a remote data")
 		}
-		_ = res.Body.Close()
+		if res != nil {
+			_ = res.Body.Close()
+		}
+		pause := d.pause(attempt)
 		d.Logger.
 			WithField("url", sourceURL).
 			WithField("attempt", attempt).
-			Debugf("Sleeping %s while requesting a remote data", d.Pause)
-		time.Sleep(d.Pause)
+			Debugf("Sleeping %s while requesting a remote data", pause)
+		time.Sleep(pause)
 	}
 	d.Logger.
 		WithField("url", sourceURL).
 		WithField("started-at", startedAt).
 		WithField("now", d.Now()).
+		WithField("waited", time.Since(waitingSince).Round(time.Second).String()).
 		Errorf("ReadinessTimeout while requesting a remote data")
 	return nil, errors.Errorf("ReadinessTimeout of %d (seconds) has been exceeded while requesting a remote data", d.ReadinessTimeout)
 }
 
+func (d *Downloader) pause(attempt int) time.Duration {
+	if d.Polling == nil {
+		return d.Pause
+	}
+	return d.Polling.Pause(attempt)
+}
+
+func (d *Downloader) isNotReadyStatus(statusCode int) bool {
+	for _, s := range d.NotReadyStatuses {
+		if s == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Downloader) createRequest(sourceURL string) *http.Request {
 	req, err := http.NewRequest(http.MethodGet, sourceURL, nil)
 	if err != nil {
@@ -125,19 +233,57 @@ func (d *Downloader) createRequest(sourceURL string) *http.Request {
 	return req
 }
 
+// getContentLength returns -1 if the response has no Content-Length-header
+func getContentLength(resp *http.Response) (int, error) {
+	header := resp.Header.Get("Content-Length")
+	if header == "" {
+		return -1, nil
+	}
+	return strconv.Atoi(header)
+}
+
 type WriteCounter struct {
-	Total    int
-	Received int
-	Logger   gologger.Logger
+	// Total is negative if the size is unknown
+	Total     int
+	Received  int
+	StartedAt time.Time
+	Progress  *ProgressReporter
+}
+
+func NewWriteCounter(total int, progress *ProgressReporter) *WriteCounter {
+	return &WriteCounter{
+		Total:     total,
+		StartedAt: time.Now(),
+		Progress:  progress,
+	}
 }
 
 func (wc *WriteCounter) Write(p []byte) (int, error) {
 	n := len(p)
 	wc.Received += n
-	wc.Logger.
-		WithField("size", n).
-		WithField("received", wc.Received).
-		WithField("total", wc.Total).
-		Debugf("Data chunk received")
+	wc.Progress.Report("Downloading a remote data", wc.State())
 	return n, nil
 }
+
+func (wc *WriteCounter) State() DownloadProgress {
+	return DownloadProgress{
+		Total:    wc.Total,
+		Received: wc.Received,
+		Elapsed:  time.Since(wc.StartedAt),
+	}
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/mingalevme/gologger"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -46,3 +47,127 @@ func TestDownloader_Download(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, responses, 0)
 }
+
+type brokenReader struct {
+	data []byte
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestDownloader_Download_Resume(t *testing.T) {
+	client := NewQueueResponseAppHttpClient()
+	client.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(&brokenReader{data: []byte("foo")}),
+		Header: map[string][]string{
+			"Content-Length": {"9"},
+		},
+	})
+	client.Enqueue(&http.Response{
+		StatusCode: 206,
+		Body:       ioutil.NopCloser(&brokenReader{data: []byte("bar")}),
+		Header: map[string][]string{
+			"Content-Range": {"bytes 3-8/9"},
+		},
+	})
+	client.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("foobarbaz")),
+		Header: map[string][]string{
+			"Content-Length": {"9"},
+		},
+	})
+	d := NewDownloader()
+	d.AppHttpClient = client
+	d.Logger = gologger.NewNullLogger()
+	d.Pause = time.Nanosecond
+	dst := &strings.Builder{}
+	err := d.Download("https://example.com/test", dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "foobarbaz", dst.String())
+	assert.Equal(t, 0, client.Size())
+}
+
+func TestDownloader_Download_Truncated(t *testing.T) {
+	client := NewQueueResponseAppHttpClient()
+	client.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("foo")),
+		Header: map[string][]string{
+			"Content-Length": {"6"},
+		},
+	})
+	d := NewDownloader()
+	d.AppHttpClient = client
+	d.Logger = gologger.NewNullLogger()
+	d.Pause = time.Nanosecond
+	d.MaxResumes = 0
+	err := d.Download("https://example.com/test", &strings.Builder{})
+	assert.Error(t, err)
+}
+
+func TestDownloader_Download_NoContentLength(t *testing.T) {
+	client := NewQueueResponseAppHttpClient()
+	client.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("foobar")),
+		Header: map[string][]string{
+			"Transfer-Encoding": {"chunked"},
+		},
+	})
+	d := NewDownloader()
+	d.AppHttpClient = client
+	d.Logger = gologger.NewNullLogger()
+	dst := &strings.Builder{}
+	err := d.Download("https://example.com/test", dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "foobar", dst.String())
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512.0 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "2.0 GiB", formatBytes(2*1024*1024*1024))
+}
+
+func TestDownloader_Download_NotReadyStatuses(t *testing.T) {
+	client := NewQueueResponseAppHttpClient()
+	client.Enqueue(&http.Response{
+		StatusCode: 404,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("not found")),
+	})
+	client.Enqueue(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("foobar")),
+		Header: map[string][]string{
+			"Content-Length": {"6"},
+		},
+	})
+	var attempts []int
+	d := NewDownloader()
+	d.AppHttpClient = client
+	d.Logger = gologger.NewNullLogger()
+	d.NotReadyStatuses = []int{403, 404}
+	d.Polling = &testPolling{attempts: &attempts}
+	dst := &strings.Builder{}
+	err := d.Download("https://example.com/test", dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "foobar", dst.String())
+	assert.Equal(t, []int{1}, attempts)
+}
+
+type testPolling struct {
+	attempts *[]int
+}
+
+func (p *testPolling) Pause(attempt int) time.Duration {
+	*p.attempts = append(*p.attempts, attempt)
+	return time.Nanosecond
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mingalevme/gologger"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const exportTimeLayout = "20060102150405"
+
+var exportFileNameRegexp = regexp.MustCompile(`^onesignal-players-(.+)-(\d{14})\.csv\.gz$`)
+
+type CachedExport struct {
+	Filename  string
+	AppId     string
+	CreatedAt time.Time
+	Size      int64
+}
+
+// ExportCache manages data files previously downloaded into Dir,
+// file names follow the pattern of Cleaner.getDestFileName.
+type ExportCache struct {
+	Dir    string
+	AppId  string
+	Now    Nower
+	Logger gologger.Logger
+}
+
+func NewExportCache(dir string, appId string) *ExportCache {
+	return &ExportCache{
+		Dir:    dir,
+		AppId:  appId,
+		Now:    Now,
+		Logger: gologger.NewStdoutLogger(gologger.LevelInfo),
+	}
+}
+
+func (c *ExportCache) FileName(createdAt int) string {
+	now := time.Unix(int64(createdAt), 0).UTC().Format(exportTimeLayout)
+	return fmt.Sprintf("%s/onesignal-players-%s-%s.csv.gz", c.Dir, c.AppId, now)
+}
+
+// parseExportFileName returns the app ID and the creation time of a data file named by Cleaner.getDestFileName
+func parseExportFileName(filename string) (string, time.Time, bool) {
+	m := exportFileNameRegexp.FindStringSubmatch(filepath.Base(filename))
+	if m == nil {
+		return "", time.Time{}, false
+	}
+	createdAt, err := time.Parse(exportTimeLayout, m[2])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return m[1], createdAt, true
+}
+
+// List returns app's cached exports, the newest first.
+func (c *ExportCache) List() ([]CachedExport, error) {
+	entries, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while reading export cache dir: %s", c.Dir)
+	}
+	var exports []CachedExport
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		appId, createdAt, ok := parseExportFileName(entry.Name())
+		if !ok || appId != c.AppId {
+			continue
+		}
+		exports = append(exports, CachedExport{
+			Filename:  filepath.Join(c.Dir, entry.Name()),
+			AppId:     appId,
+			CreatedAt: createdAt,
+			Size:      entry.Size(),
+		})
+	}
+	sort.Slice(exports, func(i, j int) bool {
+		return exports[i].CreatedAt.After(exports[j].CreatedAt)
+	})
+	return exports, nil
+}
+
+// Fresh returns the newest cached export which is not older than maxAge seconds.
+func (c *ExportCache) Fresh(maxAge int) (CachedExport, bool, error) {
+	exports, err := c.List()
+	if err != nil {
+		return CachedExport{}, false, err
+	}
+	for _, e := range exports {
+		if c.age(e) <= maxAge {
+			return e, true, nil
+		}
+	}
+	return CachedExport{}, false, nil
+}
+
+// Prune deletes cached exports older than maxAge seconds and returns their file names.
+func (c *ExportCache) Prune(maxAge int) ([]string, error) {
+	exports, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for _, e := range exports {
+		if c.age(e) <= maxAge {
+			continue
+		}
+		if err := os.Remove(e.Filename); err != nil {
+			return pruned, errors.Wrapf(err, "error while deleting cached export: %s", e.Filename)
+		}
+		c.Logger.WithField("file", e.Filename).Infof("Cached export has been deleted")
+		pruned = append(pruned, e.Filename)
+	}
+	return pruned, nil
+}
+
+func (c *ExportCache) age(e CachedExport) int {
+	return c.Now() - int(e.CreatedAt.Unix())
+}
+
+// ApplyRetention deletes cached exports except the keepLast newest ones and ones not older than keepFor seconds,
+// non-positive values disable the corresponding rule, nothing is deleted if both rules are disabled.
+func (c *ExportCache) ApplyRetention(keepLast int, keepFor int) ([]string, error) {
+	if keepLast <= 0 && keepFor <= 0 {
+		return nil, nil
+	}
+	exports, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for i, e := range exports {
+		if keepLast > 0 && i < keepLast {
+			continue
+		}
+		if keepFor > 0 && c.age(e) <= keepFor {
+			continue
+		}
+		if err := os.Remove(e.Filename); err != nil {
+			return deleted, errors.Wrapf(err, "error while deleting cached export: %s", e.Filename)
+		}
+		c.Logger.WithField("file", e.Filename).Infof("Cached export has been deleted according to the retention policy")
+		deleted = append(deleted, e.Filename)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/mingalevme/gologger"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+func TestExportCache(t *testing.T) {
+	dir := t.TempDir()
+	now := 1600000000
+	cache := NewExportCache(dir, "app-id")
+	cache.Logger = gologger.NewNullLogger()
+	cache.Now = func() int {
+		return now
+	}
+	old := cache.FileName(now - 7200)
+	fresh := cache.FileName(now - 60)
+	assert.NoError(t, ioutil.WriteFile(old, []byte("old"), 0644))
+	assert.NoError(t, ioutil.WriteFile(fresh, []byte("fresh"), 0644))
+	assert.NoError(t, ioutil.WriteFile(NewExportCache(dir, "other-app-id").FileName(now), []byte("other"), 0644))
+	assert.NoError(t, ioutil.WriteFile(fresh+".part", []byte("partial"), 0644))
+
+	exports, err := cache.List()
+	assert.NoError(t, err)
+	assert.Len(t, exports, 2)
+	assert.Equal(t, fresh, exports[0].Filename)
+	assert.Equal(t, int64(5), exports[0].Size)
+	assert.Equal(t, old, exports[1].Filename)
+
+	e, ok, err := cache.Fresh(3600)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, fresh, e.Filename)
+
+	_, ok, err = cache.Fresh(30)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	pruned, err := cache.Prune(3600)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{old}, pruned)
+	exports, err = cache.List()
+	assert.NoError(t, err)
+	assert.Len(t, exports, 1)
+}
+
+func TestExportCache_ApplyRetention(t *testing.T) {
+	dir := t.TempDir()
+	now := 1600000000
+	cache := NewExportCache(dir, "app-id")
+	cache.Logger = gologger.NewNullLogger()
+	cache.Now = func() int {
+		return now
+	}
+	var fileNames []string
+	for _, age := range []int{60, 86400, 86400 * 2, 86400 * 10} {
+		fileName := cache.FileName(now - age)
+		assert.NoError(t, ioutil.WriteFile(fileName, []byte("data"), 0644))
+		fileNames = append(fileNames, fileName)
+	}
+
+	deleted, err := cache.ApplyRetention(0, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, deleted)
+
+	deleted, err = cache.ApplyRetention(1, 86400*3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{fileNames[3]}, deleted)
+
+	deleted, err = cache.ApplyRetention(1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{fileNames[1], fileNames[2]}, deleted)
+}
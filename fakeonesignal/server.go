@@ -0,0 +1,628 @@
+// Package fakeonesignal is an in-process fake of OneSignal API for integration tests and local rehearsals.
+// It serves CSV exports, players listing, deletion and fetching, User Model deletions, its state is seeded from a CSV export.
+package fakeonesignal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// Fault is a failure injected into responses of matching requests
+type Fault struct {
+	// Status is a response status code, e.g. 429 or 500, ignored if zero
+	Status int
+	// Delay holds the response, e.g. to trigger client timeouts
+	Delay time.Duration
+	// Truncate sends only a half of the response body keeping the full Content-Length
+	Truncate bool
+	// Ignore responds {"success":true} without handling the request, e.g. to emulate eventual consistency
+	Ignore bool
+	// Times is a number of requests the fault is applied to, zero means forever
+	Times int
+}
+
+type fault struct {
+	Fault
+	method string
+	prefix string
+	used   int
+}
+
+type export struct {
+	data      []byte
+	createdAt time.Time
+	requests  int
+}
+
+// Server handles OneSignal API requests, use Start to run it with httptest or use it as an http.Handler
+type Server struct {
+	AppId      string
+	RestApiKey string
+	// NotReadyResponses is a number of 403-responses for an export before it becomes ready
+	NotReadyResponses int
+	// ReadinessDelay is a time since an export creation it is not ready for
+	ReadinessDelay time.Duration
+	mu             sync.Mutex
+	header         []string
+	players        map[string][]string
+	order          []string
+	deleted        []string
+	exports        map[string]*export
+	faults         []*fault
+	requests       []string
+	httpServer     *httptest.Server
+}
+
+func NewServer(appId string, restApiKey string) *Server {
+	return &Server{
+		AppId:      appId,
+		RestApiKey: restApiKey,
+		header:     []string{"id", "last_active", "notification_types", "tags"},
+		players:    map[string][]string{},
+		exports:    map[string]*export{},
+	}
+}
+
+// Seed replaces players with rows of a CSV export (plain or gzipped), the header is required
+func (s *Server) Seed(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var cr *csv.Reader
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "error while creating a new gzip reader")
+		}
+		defer gr.Close()
+		cr = csv.NewReader(gr)
+	} else {
+		cr = csv.NewReader(br)
+	}
+	header, err := cr.Read()
+	if err != nil {
+		return errors.Wrap(err, "error while reading a header")
+	}
+	id := indexOf(header, "id")
+	if id < 0 {
+		return errors.New("id column is missing")
+	}
+	players := map[string][]string{}
+	var order []string
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "error while reading a row")
+		}
+		if _, ok := players[row[id]]; !ok {
+			order = append(order, row[id])
+		}
+		players[row[id]] = row
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = header
+	s.players = players
+	s.order = order
+	return nil
+}
+
+func (s *Server) SeedFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrapf(err, "error while opening a seed file: %s", filename)
+	}
+	defer f.Close()
+	return s.Seed(f)
+}
+
+// AddPlayer adds a player with the id and the last active time, other columns are empty except
+// notification_types (subscribed) and tags (no tags)
+func (s *Server) AddPlayer(id string, lastActive time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row := make([]string, len(s.header))
+	for i, column := range s.header {
+		switch column {
+		case "id":
+			row[i] = id
+		case "last_active", "created_at":
+			row[i] = lastActive.UTC().Format(timeLayout)
+		case "notification_types":
+			row[i] = "1"
+		case "tags":
+			row[i] = "{}"
+		}
+	}
+	if _, ok := s.players[id]; !ok {
+		s.order = append(s.order, id)
+	}
+	s.players[id] = row
+}
+
+// InjectFault applies the fault to requests with the method (any if empty) and the path prefix
+func (s *Server) InjectFault(method string, pathPrefix string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault{Fault: f, method: method, prefix: pathPrefix})
+}
+
+// Start runs the server on a random local port
+func (s *Server) Start() string {
+	s.httpServer = httptest.NewServer(s)
+	return s.httpServer.URL
+}
+
+func (s *Server) URL() string {
+	if s.httpServer == nil {
+		return ""
+	}
+	return s.httpServer.URL
+}
+
+func (s *Server) Close() {
+	if s.httpServer != nil {
+		s.httpServer.CloseClientConnections()
+		s.httpServer.Close()
+	}
+}
+
+// Players returns IDs of existing players
+func (s *Server) Players() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.players))
+	for _, id := range s.order {
+		if _, ok := s.players[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Deleted returns IDs of deleted players in order of deletion
+func (s *Server) Deleted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deleted...)
+}
+
+// Requests returns handled requests as "METHOD /path"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	f := s.matchFault(r)
+	s.mu.Unlock()
+	if f != nil {
+		if f.Delay > 0 {
+			select {
+			case <-time.After(f.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.Status > 0 {
+			if f.Status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			writeJson(w, f.Status, map[string]interface{}{"errors": []string{http.StatusText(f.Status)}})
+			return
+		}
+		if f.Ignore {
+			writeJson(w, http.StatusOK, map[string]interface{}{"success": true})
+			return
+		}
+		if f.Truncate {
+			w = &truncatingResponseWriter{ResponseWriter: w}
+		}
+	}
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && path == "/api/v1/players/csv_export":
+		s.handleCsvExport(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/csv_exports/"):
+		s.handleDownload(w, r, strings.TrimPrefix(path, "/csv_exports/"))
+	case r.Method == http.MethodGet && path == "/api/v1/players":
+		s.handleViewPlayers(w, r)
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/api/v1/apps/"):
+		s.handleUserModelDeletion(w, r, strings.Split(strings.TrimPrefix(path, "/api/v1/apps/"), "/"))
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/api/v1/players/"):
+		s.handleDeletePlayer(w, r, strings.TrimPrefix(path, "/api/v1/players/"))
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/api/v1/players/"):
+		s.handleEditPlayer(w, r, strings.TrimPrefix(path, "/api/v1/players/"))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/api/v1/players/"):
+		s.handleGetPlayer(w, r, strings.TrimPrefix(path, "/api/v1/players/"))
+	default:
+		writeJson(w, http.StatusNotFound, map[string]interface{}{"errors": []string{"Not Found"}})
+	}
+}
+
+func (s *Server) matchFault(r *http.Request) *fault {
+	for _, f := range s.faults {
+		if f.method != "" && f.method != r.Method {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, f.prefix) {
+			continue
+		}
+		if f.Times > 0 && f.used >= f.Times {
+			continue
+		}
+		f.used++
+		return f
+	}
+	return nil
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Query().Get("app_id") != s.AppId {
+		writeJson(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"app_id not found"}})
+		return false
+	}
+	if r.Header.Get("Authorization") != "Basic "+s.RestApiKey {
+		writeJson(w, http.StatusUnauthorized, map[string]interface{}{"errors": []string{"Please include a case-sensitive header of Authorization: Basic <YOUR-REST-API-KEY-HERE> with a valid REST API key."}})
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleCsvExport(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	data, err := s.export()
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]interface{}{"errors": []string{err.Error()}})
+		return
+	}
+	s.mu.Lock()
+	name := fmt.Sprintf("users_%s_%d.csv.gz", s.AppId, len(s.exports)+1)
+	s.exports[name] = &export{
+		data:      data,
+		createdAt: time.Now(),
+	}
+	s.mu.Unlock()
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"csv_file_url": fmt.Sprintf("%s://%s/csv_exports/%s", scheme, r.Host, name),
+	})
+}
+
+func (s *Server) export() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	cw := csv.NewWriter(gw)
+	_ = cw.Write(s.header)
+	for _, id := range s.order {
+		if row, ok := s.players[id]; ok {
+			_ = cw.Write(row)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return nil, errors.Wrap(err, "error while writing an export")
+	}
+	if err := gw.Close(); err != nil {
+		return nil, errors.Wrap(err, "error while compressing an export")
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	e, ok := s.exports[name]
+	ready := false
+	if ok {
+		e.requests++
+		ready = e.requests > s.NotReadyResponses && time.Since(e.createdAt) >= s.ReadinessDelay
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if !ready {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	http.ServeContent(w, r, name, e.createdAt, bytes.NewReader(e.data))
+}
+
+func (s *Server) handleDeletePlayer(w http.ResponseWriter, r *http.Request, id string) {
+	if !s.authorize(w, r) {
+		return
+	}
+	s.mu.Lock()
+	_, ok := s.players[id]
+	if ok {
+		delete(s.players, id)
+		s.deleted = append(s.deleted, id)
+		s.order = removeString(s.order, id)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeJson(w, http.StatusNotFound, map[string]interface{}{"success": false})
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// handleUserModelDeletion deletes a subscription (apps/{app_id}/subscriptions/{id}) or all the players of a user
+// (apps/{app_id}/users/by/{label}/{id}), an alias is a column of the same name, external_id falls back
+// to external_user_id column.
+func (s *Server) handleUserModelDeletion(w http.ResponseWriter, r *http.Request, parts []string) {
+	if parts[0] != s.AppId {
+		writeJson(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"app_id not found"}})
+		return
+	}
+	if r.Header.Get("Authorization") != "Basic "+s.RestApiKey {
+		writeJson(w, http.StatusUnauthorized, map[string]interface{}{"errors": []string{"Access denied"}})
+		return
+	}
+	s.mu.Lock()
+	var ids []string
+	switch {
+	case len(parts) == 3 && parts[1] == "subscriptions":
+		if _, ok := s.players[parts[2]]; ok {
+			ids = append(ids, parts[2])
+		}
+	case len(parts) == 5 && parts[1] == "users" && parts[2] == "by":
+		column := indexOf(s.header, parts[3])
+		if column < 0 && parts[3] == "external_id" {
+			column = indexOf(s.header, "external_user_id")
+		}
+		for _, id := range s.order {
+			row, ok := s.players[id]
+			if ok && column >= 0 && column < len(row) && row[column] == parts[4] {
+				ids = append(ids, id)
+			}
+		}
+	default:
+		s.mu.Unlock()
+		writeJson(w, http.StatusNotFound, map[string]interface{}{"errors": []string{"Not Found"}})
+		return
+	}
+	for _, id := range ids {
+		delete(s.players, id)
+		s.deleted = append(s.deleted, id)
+		s.order = removeString(s.order, id)
+	}
+	s.mu.Unlock()
+	if len(ids) == 0 {
+		writeJson(w, http.StatusNotFound, map[string]interface{}{"errors": []string{"Not Found"}})
+		return
+	}
+	writeJson(w, http.StatusAccepted, map[string]interface{}{})
+}
+
+// handleEditPlayer updates tags (merging them, empty values remove tags) and other columns of the player
+func (s *Server) handleEditPlayer(w http.ResponseWriter, r *http.Request, id string) {
+	if !s.authorize(w, r) {
+		return
+	}
+	var fields map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{err.Error()}})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, ok := s.players[id]
+	if !ok {
+		writeJson(w, http.StatusNotFound, map[string]interface{}{"errors": []string{"No user with this id found"}})
+		return
+	}
+	for column, value := range fields {
+		i := indexOf(s.header, column)
+		if column == "app_id" || i < 0 {
+			continue
+		}
+		if column == "tags" {
+			tags := map[string]interface{}{}
+			_ = json.Unmarshal([]byte(row[i]), &tags)
+			if edited, ok := value.(map[string]interface{}); ok {
+				for k, v := range edited {
+					if v == "" || v == nil {
+						delete(tags, k)
+					} else {
+						tags[k] = v
+					}
+				}
+			}
+			data, _ := json.Marshal(tags)
+			row[i] = string(data)
+			continue
+		}
+		row[i] = fmt.Sprint(value)
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// Player returns the player row by columns or nil if the player does not exist
+func (s *Server) Player(id string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, ok := s.players[id]
+	if !ok {
+		return nil
+	}
+	player := map[string]string{}
+	for i, column := range s.header {
+		if i < len(row) {
+			player[column] = row[i]
+		}
+	}
+	return player
+}
+
+func (s *Server) handleGetPlayer(w http.ResponseWriter, r *http.Request, id string) {
+	if !s.authorize(w, r) {
+		return
+	}
+	s.mu.Lock()
+	row, ok := s.players[id]
+	var player map[string]interface{}
+	if ok {
+		// Rows are edited in place (see handleEditPlayer), so the player is encoded under the lock
+		player = playerJson(s.header, row)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeJson(w, http.StatusNotFound, map[string]interface{}{"errors": []string{"No user with this id found"}})
+		return
+	}
+	writeJson(w, http.StatusOK, player)
+}
+
+// handleViewPlayers serves players page by page in order of seeding, limit is 300 at most
+func (s *Server) handleViewPlayers(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 300 {
+		limit = 300
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	s.mu.Lock()
+	var ids []string
+	for _, id := range s.order {
+		if _, ok := s.players[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	players := []map[string]interface{}{}
+	for i := offset; i < len(ids) && i < offset+limit; i++ {
+		players = append(players, playerJson(s.header, s.players[ids[i]]))
+	}
+	s.mu.Unlock()
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"total_count": len(ids),
+		"offset":      offset,
+		"limit":       limit,
+		"players":     players,
+	})
+}
+
+// playerJson converts a CSV export row to a View device response payload
+func playerJson(header []string, row []string) map[string]interface{} {
+	player := map[string]interface{}{}
+	for i, column := range header {
+		if i >= len(row) {
+			break
+		}
+		value := row[i]
+		switch column {
+		case "session_count", "timezone", "device_type", "playtime", "badge_count", "notification_types":
+			if n, err := strconv.Atoi(value); err == nil {
+				player[column] = n
+			} else {
+				player[column] = nil
+			}
+		case "last_active", "created_at":
+			if t, err := time.Parse(timeLayout, value); err == nil {
+				player[column] = t.Unix()
+			} else if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				player[column] = n
+			} else {
+				player[column] = nil
+			}
+		case "invalid_identifier", "rooted":
+			player[column] = value == "t" || value == "true" || value == "1"
+		case "tags":
+			tags := map[string]interface{}{}
+			_ = json.Unmarshal([]byte(value), &tags)
+			player[column] = tags
+		case "amount_spent":
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				player[column] = f
+			} else {
+				player[column] = 0
+			}
+		default:
+			player[column] = value
+		}
+	}
+	return player
+}
+
+func writeJson(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+// truncatingResponseWriter writes only a half of the body declared by Content-Length
+type truncatingResponseWriter struct {
+	http.ResponseWriter
+	limit   int
+	written int
+}
+
+func (w *truncatingResponseWriter) WriteHeader(status int) {
+	if n, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil {
+		w.limit = n / 2
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *truncatingResponseWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > w.limit {
+		p = p[:w.limit-w.written]
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += n
+	if err == nil && w.written >= w.limit {
+		return n, errors.New("response body has been truncated")
+	}
+	return n, err
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeString(values []string, value string) []string {
+	for i, v := range values {
+		if v == value {
+			return append(values[:i], values[i+1:]...)
+		}
+	}
+	return values
+}
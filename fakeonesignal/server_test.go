@@ -0,0 +1,233 @@
+package fakeonesignal
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const seed = `id,identifier,session_count,tags,last_active
+id1,token1,10,"{""level"":""5""}",2020-01-01 00:00:00
+id2,token2,1,{},2021-06-01 12:30:00
+`
+
+func newTestServer(t *testing.T) *Server {
+	s := NewServer("app-id", "rest-api-key")
+	assert.NoError(t, s.Seed(strings.NewReader(seed)))
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func request(t *testing.T, method string, url string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Basic rest-api-key")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func TestServer_Export(t *testing.T) {
+	s := newTestServer(t)
+	s.NotReadyResponses = 2
+
+	resp := request(t, http.MethodPost, s.URL()+"/api/v1/players/csv_export?app_id=app-id")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		CsvFileUrl string `json:"csv_file_url"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	_ = resp.Body.Close()
+	assert.True(t, strings.HasPrefix(body.CsvFileUrl, s.URL()+"/csv_exports/"))
+
+	for i := 0; i < 2; i++ {
+		resp = request(t, http.MethodGet, body.CsvFileUrl)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+	resp = request(t, http.MethodGet, body.CsvFileUrl)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	gr, err := gzip.NewReader(resp.Body)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, seed, string(data))
+}
+
+func TestServer_Unauthorized(t *testing.T) {
+	s := newTestServer(t)
+	req, _ := http.NewRequest(http.MethodDelete, s.URL()+"/api/v1/players/id1?app_id=app-id", nil)
+	req.Header.Set("Authorization", "Basic wrong")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, []string{"id1", "id2"}, s.Players())
+}
+
+func TestServer_Players(t *testing.T) {
+	s := newTestServer(t)
+
+	resp := request(t, http.MethodGet, s.URL()+"/api/v1/players/id1?app_id=app-id")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var player map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&player))
+	_ = resp.Body.Close()
+	assert.Equal(t, "token1", player["identifier"])
+	assert.Equal(t, float64(10), player["session_count"])
+	assert.Equal(t, map[string]interface{}{"level": "5"}, player["tags"])
+	assert.Equal(t, float64(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Unix()), player["last_active"])
+
+	resp = request(t, http.MethodDelete, s.URL()+"/api/v1/players/id1?app_id=app-id")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = request(t, http.MethodDelete, s.URL()+"/api/v1/players/id1?app_id=app-id")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = request(t, http.MethodGet, s.URL()+"/api/v1/players/id1?app_id=app-id")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	assert.Equal(t, []string{"id1"}, s.Deleted())
+	assert.Equal(t, []string{"id2"}, s.Players())
+}
+
+func TestServer_InjectFault(t *testing.T) {
+	s := newTestServer(t)
+	s.InjectFault(http.MethodDelete, "/api/v1/players/", Fault{Status: http.StatusTooManyRequests, Times: 1})
+	s.InjectFault("", "/api/v1/players/id2", Fault{Status: http.StatusInternalServerError})
+
+	resp := request(t, http.MethodDelete, s.URL()+"/api/v1/players/id1?app_id=app-id")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	resp = request(t, http.MethodDelete, s.URL()+"/api/v1/players/id1?app_id=app-id")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = request(t, http.MethodDelete, s.URL()+"/api/v1/players/id2?app_id=app-id")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, []string{"id2"}, s.Players())
+}
+
+func TestServer_InjectFault_Truncate(t *testing.T) {
+	s := newTestServer(t)
+	s.InjectFault(http.MethodGet, "/csv_exports/", Fault{Truncate: true, Times: 1})
+
+	resp := request(t, http.MethodPost, s.URL()+"/api/v1/players/csv_export?app_id=app-id")
+	var body struct {
+		CsvFileUrl string `json:"csv_file_url"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	_ = resp.Body.Close()
+
+	resp = request(t, http.MethodGet, body.CsvFileUrl)
+	_, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Error(t, err)
+	resp = request(t, http.MethodGet, body.CsvFileUrl)
+	_, err = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.NoError(t, err)
+}
+
+func TestServer_InjectFault_Delay(t *testing.T) {
+	s := newTestServer(t)
+	s.InjectFault("", "/api/v1/players/", Fault{Delay: time.Second})
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	_, err := client.Get(s.URL() + "/api/v1/players/id1?app_id=app-id")
+	assert.Error(t, err)
+}
+
+func TestServer_EditPlayer(t *testing.T) {
+	s := newTestServer(t)
+	req, err := http.NewRequest(http.MethodPut, s.URL()+"/api/v1/players/id1?app_id=app-id",
+		strings.NewReader(`{"app_id":"app-id","tags":{"level":"","stale_since":"2022-01-01"},"session_count":11}`))
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Basic rest-api-key")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	player := s.Player("id1")
+	assert.Equal(t, `{"stale_since":"2022-01-01"}`, player["tags"])
+	assert.Equal(t, "11", player["session_count"])
+	assert.Nil(t, s.Player("id3"))
+}
+
+func TestServer_ViewPlayers(t *testing.T) {
+	s := newTestServer(t)
+	var page struct {
+		TotalCount int                      `json:"total_count"`
+		Offset     int                      `json:"offset"`
+		Players    []map[string]interface{} `json:"players"`
+	}
+	resp := request(t, http.MethodGet, s.URL()+"/api/v1/players?app_id=app-id&limit=1&offset=1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	_ = resp.Body.Close()
+	assert.Equal(t, 2, page.TotalCount)
+	assert.Equal(t, 1, page.Offset)
+	assert.Len(t, page.Players, 1)
+	assert.Equal(t, "id2", page.Players[0]["id"])
+}
+
+func TestServer_UserModelDeletion(t *testing.T) {
+	s := NewServer("app-id", "rest-api-key")
+	assert.NoError(t, s.Seed(strings.NewReader(`id,last_active,external_user_id
+id1,2020-01-01 00:00:00,user1
+id2,2020-01-01 00:00:00,user1
+id3,2020-01-01 00:00:00,user3
+`)))
+	s.Start()
+	t.Cleanup(s.Close)
+
+	resp := request(t, http.MethodDelete, s.URL()+"/api/v1/apps/app-id/subscriptions/id3")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	resp = request(t, http.MethodDelete, s.URL()+"/api/v1/apps/app-id/subscriptions/id3")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = request(t, http.MethodDelete, s.URL()+"/api/v1/apps/other-app-id/users/by/external_id/user1")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = request(t, http.MethodDelete, s.URL()+"/api/v1/apps/app-id/users/by/external_id/user1")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	assert.Equal(t, []string{"id3", "id1", "id2"}, s.Deleted())
+	assert.Empty(t, s.Players())
+}
+
+// go test -race catches players being read while they are edited
+func TestServer_ConcurrentEditAndGet(t *testing.T) {
+	s := NewServer("app-id", "rest-api-key")
+	assert.NoError(t, s.Seed(strings.NewReader(seed)))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/players/id1?app_id=app-id", strings.NewReader(`{"app_id":"app-id","tags":{"level":"6"}}`))
+			req.Header.Set("Authorization", "Basic rest-api-key")
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/players/id1?app_id=app-id", nil)
+		req.Header.Set("Authorization", "Basic rest-api-key")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	<-done
+}
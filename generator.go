@@ -0,0 +1,286 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Weighted is a value picked with a probability proportional to Weight
+type Weighted struct {
+	Value  string
+	Weight float64
+}
+
+// ParseWeighted parses a comma separated list of value:weight-pairs, e.g. "0:40,1:60"
+func ParseWeighted(spec string) ([]Weighted, error) {
+	var items []Weighted
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, ":")
+		if i <= 0 {
+			return nil, errors.Errorf("invalid value:weight-pair: %s", pair)
+		}
+		weight, err := strconv.ParseFloat(pair[i+1:], 64)
+		if err != nil || weight < 0 {
+			return nil, errors.Errorf("invalid weight of value:weight-pair: %s", pair)
+		}
+		items = append(items, Weighted{Value: pair[:i], Weight: weight})
+	}
+	if len(items) == 0 {
+		return nil, errors.Errorf("empty value:weight-list: %s", spec)
+	}
+	return items, nil
+}
+
+func pickWeighted(rnd *rand.Rand, items []Weighted) string {
+	total := 0.0
+	for _, item := range items {
+		total += item.Weight
+	}
+	x := rnd.Float64() * total
+	for _, item := range items {
+		if x < item.Weight {
+			return item.Value
+		}
+		x -= item.Weight
+	}
+	return items[len(items)-1].Value
+}
+
+type GeneratorStats struct {
+	Rows              int
+	Malformed         int
+	InvalidIdentifier int
+	// LastActiveAges is a number of valid rows per last_active age bucket (max age in days)
+	LastActiveAges map[string]int
+}
+
+// ExportGenerator writes synthetic exports in OneSignal CSV export format, e.g. for load testing
+type ExportGenerator struct {
+	Rows int
+	// LastActiveAges are buckets of max last_active age in days, an age is uniformly distributed within a bucket,
+	// e.g. {"30", 50}, {"365", 50} makes a half of players active within 30 days and a half within 30-365 days
+	LastActiveAges []Weighted
+	// DeviceTypes are OneSignal device types, e.g. 0 (iOS), 1 (Android), 5 (Chrome Web Push)
+	DeviceTypes            []Weighted
+	Tags                   int
+	InvalidIdentifierRatio float64
+	// MalformedRatio is a ratio of intentionally malformed rows (ragged rows, unparsable or empty required fields)
+	MalformedRatio float64
+	Rand           *rand.Rand
+	Now            time.Time
+}
+
+func NewExportGenerator(rows int) *ExportGenerator {
+	return &ExportGenerator{
+		Rows: rows,
+		LastActiveAges: []Weighted{
+			{"30", 40},
+			{"180", 20},
+			{"365", 15},
+			{"1095", 25},
+		},
+		DeviceTypes: []Weighted{
+			{"0", 40},
+			{"1", 55},
+			{"5", 5},
+		},
+		Tags: 2,
+		Rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		Now:  time.Now(),
+	}
+}
+
+func (g *ExportGenerator) GenerateFile(filename string) (GeneratorStats, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return GeneratorStats{}, errors.Wrapf(err, "error while creating a file: %s", filename)
+	}
+	stats, err := g.Generate(f)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = errors.Wrapf(closeErr, "error while closing a file: %s", filename)
+	}
+	return stats, err
+}
+
+// Generate writes a gzipped CSV export into w
+func (g *ExportGenerator) Generate(w io.Writer) (GeneratorStats, error) {
+	stats := GeneratorStats{
+		LastActiveAges: map[string]int{},
+	}
+	ages, err := g.ageBuckets()
+	if err != nil {
+		return stats, err
+	}
+	gw := gzip.NewWriter(w)
+	cw := csv.NewWriter(gw)
+	if err := cw.Write(OneSignalExportColumns); err != nil {
+		return stats, errors.Wrap(err, "error while writing a header")
+	}
+	for i := 0; i < g.Rows; i++ {
+		row, bucket, invalid := g.row(i, ages)
+		if g.Rand.Float64() < g.MalformedRatio {
+			row = g.malform(row)
+			stats.Malformed++
+		} else {
+			stats.LastActiveAges[bucket]++
+			if invalid {
+				stats.InvalidIdentifier++
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return stats, errors.Wrapf(err, "error while writing row #%d", i+1)
+		}
+		stats.Rows++
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return stats, errors.Wrap(err, "error while writing rows")
+	}
+	if err := gw.Close(); err != nil {
+		return stats, errors.Wrap(err, "error while compressing rows")
+	}
+	return stats, nil
+}
+
+type ageBucket struct {
+	name string
+	min  time.Duration
+	max  time.Duration
+}
+
+func (g *ExportGenerator) ageBuckets() (map[string]ageBucket, error) {
+	days := map[string]int{}
+	for _, item := range g.LastActiveAges {
+		d, err := strconv.Atoi(item.Value)
+		if err != nil || d <= 0 {
+			return nil, errors.Errorf("invalid last active age (days): %s", item.Value)
+		}
+		days[item.Value] = d
+	}
+	names := make([]string, 0, len(days))
+	for name := range days {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return days[names[i]] < days[names[j]]
+	})
+	buckets := map[string]ageBucket{}
+	prev := 0
+	for _, name := range names {
+		if days[name] == prev {
+			return nil, errors.Errorf("duplicate last active age (days): %s", name)
+		}
+		buckets[name] = ageBucket{
+			name: name,
+			min:  time.Duration(prev) * 24 * time.Hour,
+			max:  time.Duration(days[name]) * 24 * time.Hour,
+		}
+		prev = days[name]
+	}
+	return buckets, nil
+}
+
+func (g *ExportGenerator) row(i int, ages map[string]ageBucket) ([]string, string, bool) {
+	bucket := ages[pickWeighted(g.Rand, g.LastActiveAges)]
+	age := bucket.min + time.Duration(g.Rand.Int63n(int64(bucket.max-bucket.min)))
+	lastActive := g.Now.Add(-age).UTC()
+	createdAt := lastActive.Add(-time.Duration(g.Rand.Int63n(int64(365 * 24 * time.Hour))))
+	deviceType := pickWeighted(g.Rand, g.DeviceTypes)
+	invalid := g.Rand.Float64() < g.InvalidIdentifierRatio
+	tags := map[string]string{}
+	for t := 0; t < g.Tags; t++ {
+		tags[fmt.Sprintf("tag_%d", t)] = strconv.Itoa(g.Rand.Intn(100))
+	}
+	tagsJson, _ := json.Marshal(tags)
+	values := map[string]string{
+		"id":                 g.uuid(),
+		"identifier":         g.hex(32),
+		"session_count":      strconv.Itoa(1 + g.Rand.Intn(500)),
+		"language":           []string{"en", "de", "fr", "es", "ru"}[g.Rand.Intn(5)],
+		"timezone":           strconv.Itoa((g.Rand.Intn(25) - 12) * 3600),
+		"game_version":       fmt.Sprintf("1.%d.%d", g.Rand.Intn(10), g.Rand.Intn(10)),
+		"device_type":        deviceType,
+		"ad_id":              g.uuid(),
+		"tags":               string(tagsJson),
+		"last_active":        lastActive.Format(OneSignalTimeLayout),
+		"playtime":           strconv.Itoa(g.Rand.Intn(100000)),
+		"amount_spent":       fmt.Sprintf("%.2f", g.Rand.Float64()*10),
+		"created_at":         createdAt.Format(OneSignalTimeLayout),
+		"invalid_identifier": strconv.FormatBool(invalid),
+		"badge_count":        strconv.Itoa(g.Rand.Intn(10)),
+		"country":            []string{"US", "DE", "FR", "ES", "RU"}[g.Rand.Intn(5)],
+		"rooted":             "false",
+		"notification_types": "1",
+		"external_user_id":   fmt.Sprintf("user-%d", i+1),
+	}
+	switch deviceType {
+	case "0":
+		values["device_os"] = fmt.Sprintf("%d.%d", 13+g.Rand.Intn(4), g.Rand.Intn(5))
+		values["device_model"] = "iPhone" + strconv.Itoa(10+g.Rand.Intn(5))
+	case "1":
+		values["device_os"] = strconv.Itoa(9 + g.Rand.Intn(5))
+		values["device_model"] = "Pixel " + strconv.Itoa(3+g.Rand.Intn(5))
+	default:
+		values["device_os"] = strconv.Itoa(90 + g.Rand.Intn(20))
+		values["web_auth"] = g.hex(16)
+		values["web_p256"] = g.hex(32)
+	}
+	if invalid {
+		values["notification_types"] = "-3"
+	}
+	row := make([]string, len(OneSignalExportColumns))
+	for j, column := range OneSignalExportColumns {
+		row[j] = values[column]
+	}
+	return row, bucket.name, invalid
+}
+
+// malform breaks the row in one of the ways met in real exports
+func (g *ExportGenerator) malform(row []string) []string {
+	switch g.Rand.Intn(4) {
+	case 0:
+		return row[:len(row)-1-g.Rand.Intn(len(row)-1)]
+	case 1:
+		return append(row, "extra")
+	case 2:
+		row[indexOf(OneSignalExportColumns, "last_active")] = "not-a-timestamp"
+	default:
+		row[indexOf(OneSignalExportColumns, "id")] = ""
+	}
+	return row
+}
+
+func (g *ExportGenerator) uuid() string {
+	b := make([]byte, 16)
+	_, _ = g.Rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (g *ExportGenerator) hex(n int) string {
+	b := make([]byte, n)
+	_, _ = g.Rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
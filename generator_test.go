@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseWeighted(t *testing.T) {
+	items, err := ParseWeighted("0:40, 1:55.5,5:0")
+	assert.NoError(t, err)
+	assert.Equal(t, []Weighted{{"0", 40}, {"1", 55.5}, {"5", 0}}, items)
+
+	for _, spec := range []string{"", "0", "0:x", "0:-1", ":1"} {
+		_, err := ParseWeighted(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestExportGenerator_Generate(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	filename := t.TempDir() + "/export.csv.gz"
+	generator := NewExportGenerator(1000)
+	generator.Rand = rand.New(rand.NewSource(1))
+	generator.Now = now
+	generator.LastActiveAges = []Weighted{{"30", 1}, {"365", 1}}
+	generator.DeviceTypes = []Weighted{{"1", 1}}
+	generator.InvalidIdentifierRatio = 0.1
+	generator.MalformedRatio = 0.1
+	stats, err := generator.GenerateFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, stats.Rows)
+	assert.InDelta(t, 100, stats.Malformed, 30)
+	assert.InDelta(t, 90, stats.InvalidIdentifier, 30)
+	assert.Equal(t, 1000-stats.Malformed, stats.LastActiveAges["30"]+stats.LastActiveAges["365"])
+
+	r, err := NewGzCsvReader(filename)
+	assert.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, OneSignalExportColumns, r.Header())
+	valid, invalid := 0, 0
+	for {
+		pd, err := r.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		record, errs := ParsePlayerRecord(pd, nil)
+		if len(errs) > 0 {
+			invalid++
+			continue
+		}
+		valid++
+		assert.Equal(t, 1, record.DeviceType)
+		assert.Len(t, record.Tags, 2)
+		assert.True(t, record.LastActive.Before(now))
+		assert.True(t, record.LastActive.After(now.AddDate(0, 0, -365)))
+	}
+	assert.Equal(t, 1000-stats.Malformed, valid)
+	assert.Equal(t, stats.Rows, valid+invalid+r.Skipped)
+}
+
+func TestExportGenerator_Generate_InvalidAges(t *testing.T) {
+	generator := NewExportGenerator(1)
+	generator.LastActiveAges = []Weighted{{"x", 1}}
+	_, err := generator.Generate(io.Discard)
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ module onesignal-cleaner
 go 1.17
 
 require (
+	github.com/klauspost/compress v1.15.9
 	github.com/mingalevme/gologger v0.0.2
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli/v2 v2.3.0
@@ -17,7 +18,7 @@ require (
 	github.com/rollbar/rollbar-go v1.4.1 // indirect
 	github.com/russross/blackfriday/v2 v2.0.1 // indirect
 	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
-	github.com/sirupsen/logrus v1.8.1 // indirect
+	github.com/sirupsen/logrus v1.8.1
 	golang.org/x/sys v0.0.0-20191026070338-33540a1f6037 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
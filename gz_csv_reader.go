@@ -1,23 +1,99 @@
 package main
 
 import (
-	"compress/gzip"
 	"encoding/csv"
+	"fmt"
 	"github.com/pkg/errors"
 	"io"
 	"os"
+	"strings"
 )
 
+type RaggedRowsPolicy string
+
+const (
+	// RaggedRowsSkip skips rows with a number of fields different from the header's one
+	RaggedRowsSkip RaggedRowsPolicy = "skip"
+	// RaggedRowsPad pads missing fields with empty values and drops extra fields
+	RaggedRowsPad RaggedRowsPolicy = "pad"
+	// RaggedRowsError fails on a row with a number of fields different from the header's one
+	RaggedRowsError RaggedRowsPolicy = "error"
+)
+
+func ParseRaggedRowsPolicy(policy string) (RaggedRowsPolicy, error) {
+	switch RaggedRowsPolicy(strings.ToLower(policy)) {
+	case RaggedRowsSkip:
+		return RaggedRowsSkip, nil
+	case RaggedRowsPad:
+		return RaggedRowsPad, nil
+	case RaggedRowsError:
+		return RaggedRowsError, nil
+	default:
+		return "", errors.Errorf("unknown ragged rows policy: %s", policy)
+	}
+}
+
+type CsvOptions struct {
+	RaggedRows RaggedRowsPolicy
+	// Strict fails on the first malformed row (a ragged or unparsable one)
+	Strict     bool
+	LazyQuotes bool
+	// FieldsPerRecord has the same meaning as csv.Reader.FieldsPerRecord, but the default (0) means
+	// the number of fields is checked according to RaggedRows
+	FieldsPerRecord int
+	// OnMalformedRow is called for every skipped row
+	OnMalformedRow func(err *MalformedRowError)
+	// AsyncDecompression decompresses data in a separate goroutine concurrently with CSV decoding
+	AsyncDecompression bool
+}
+
+func DefaultCsvOptions() CsvOptions {
+	return CsvOptions{
+		RaggedRows: RaggedRowsSkip,
+	}
+}
+
+type MalformedRowError struct {
+	// Line is a number of the row in the file, the header is line 1
+	Line int
+	Err  error
+}
+
+func (e *MalformedRowError) Error() string {
+	return fmt.Sprintf("malformed row at line %d: %s", e.Line, e.Err)
+}
+
+func (e *MalformedRowError) Unwrap() error {
+	return e.Err
+}
+
+// GzCsvReader reads players data in CSV format, gzip, zstd and bzip2 compressions are auto-detected
 type GzCsvReader struct {
-	Filename  string
+	Filename    string
+	Compression Compression
+	Options     CsvOptions
+	// Skipped is a number of skipped malformed rows
+	Skipped   int
+	source    io.Reader
 	file      *os.File
-	reader    *gzip.Reader
+	reader    io.ReadCloser
+	async     *asyncReader
 	csvReader *csv.Reader
 	header    []string
 }
 
-func NewGzCsvReader(filename string) (*GzCsvReader, error) {
-	reader := &GzCsvReader{Filename: filename}
+func NewGzCsvReader(filename string, options ...CsvOptions) (*GzCsvReader, error) {
+	reader := &GzCsvReader{Filename: filename, Options: getCsvOptions(options)}
+	if err := reader.init(); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// NewGzCsvReaderFromReader reads players data from r (e.g. a response body), r is not closed by the reader
+func NewGzCsvReaderFromReader(r io.Reader, options ...CsvOptions) (*GzCsvReader, error) {
+	reader := &GzCsvReader{source: r, Options: getCsvOptions(options)}
 	if err := reader.init(); err != nil {
 		reader.Close()
 		return nil, err
@@ -25,43 +101,119 @@ func NewGzCsvReader(filename string) (*GzCsvReader, error) {
 	return reader, nil
 }
 
+func getCsvOptions(options []CsvOptions) CsvOptions {
+	if len(options) > 0 {
+		return options[0]
+	}
+	return DefaultCsvOptions()
+}
+
 func (r *GzCsvReader) init() error {
-	f, err := os.Open(r.Filename)
-	if err != nil {
-		return errors.Wrap(err, "error while opening players data file")
+	if r.source == nil {
+		f, err := os.Open(r.Filename)
+		if err != nil {
+			return errors.Wrap(err, "error while opening players data file")
+		}
+		r.file = f
+		r.source = f
 	}
-	r.file = f
-	gr, err := gzip.NewReader(f)
+	dr, compression, err := newDecompressor(r.source)
 	if err != nil {
-		return errors.Wrap(err, "error while creating a new gzip reader")
+		return err
+	}
+	r.reader = dr
+	r.Compression = compression
+	if r.Options.AsyncDecompression {
+		r.async = newAsyncReader(dr, 64*1024, 16)
+		r.csvReader = csv.NewReader(r.async)
+	} else {
+		r.csvReader = csv.NewReader(dr)
 	}
-	r.reader = gr
-	r.csvReader = csv.NewReader(gr)
+	r.csvReader.LazyQuotes = r.Options.LazyQuotes
+	r.csvReader.FieldsPerRecord = r.Options.FieldsPerRecord
+	if r.Options.FieldsPerRecord == 0 {
+		// The number of fields is checked by ReadLine
+		r.csvReader.FieldsPerRecord = -1
+	}
+	r.csvReader.ReuseRecord = true
 	header, err := r.csvReader.Read()
 	if err != nil {
 		return errors.Wrap(err, "error while reading header line")
 	}
+	header = append([]string(nil), header...)
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	seen := map[string]bool{}
+	for _, column := range header {
+		if seen[column] {
+			return errors.Errorf("duplicate header column: %s", column)
+		}
+		seen[column] = true
+	}
 	r.header = header
 	return nil
 }
 
+func (r *GzCsvReader) Header() []string {
+	return r.header
+}
+
 func (r *GzCsvReader) ReadLine() (map[string]string, error) {
-	rec, err := r.csvReader.Read()
-	if err != nil {
+	for {
+		rec, err := r.csvReader.Read()
 		if err == io.EOF {
 			return nil, err
 		}
-		return nil, errors.Wrap(err, "error while reading line")
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, errors.Wrap(err, "error while reading line")
+			}
+			// csv.Reader counts lines (a quoted field can be multiline), not rows
+			malformed := &MalformedRowError{Line: parseErr.StartLine, Err: parseErr.Err}
+			if r.Options.Strict {
+				return nil, malformed
+			}
+			r.skip(malformed)
+			continue
+		}
+		if len(rec) != len(r.header) {
+			// The line of the record start as ParseError.StartLine, a quoted field can be multiline
+			line, _ := r.csvReader.FieldPos(0)
+			malformed := &MalformedRowError{
+				Line: line,
+				Err:  errors.Errorf("wrong number of fields: %d, expected %d", len(rec), len(r.header)),
+			}
+			if r.Options.Strict || r.Options.RaggedRows == RaggedRowsError {
+				return nil, malformed
+			}
+			if r.Options.RaggedRows != RaggedRowsPad {
+				r.skip(malformed)
+				continue
+			}
+		}
+		data := make(map[string]string, len(r.header))
+		for i, k := range r.header {
+			if i < len(rec) {
+				data[k] = rec[i]
+			} else {
+				data[k] = ""
+			}
+		}
+		return data, nil
 	}
-	data := map[string]string{}
-	for i, v := range rec {
-		k := r.header[i]
-		data[k] = v
+}
+
+func (r *GzCsvReader) skip(err *MalformedRowError) {
+	r.Skipped += 1
+	if r.Options.OnMalformedRow != nil {
+		r.Options.OnMalformedRow(err)
 	}
-	return data, nil
 }
 
 func (r *GzCsvReader) Close() {
+	if r.async != nil {
+		r.async.Close()
+	}
 	if r.file != nil {
 		_ = r.file.Close()
 	}
@@ -3,7 +3,9 @@ package main
 import (
 	"github.com/stretchr/testify/assert"
 	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -24,3 +26,113 @@ func TestGzCsvReader(t *testing.T) {
 	assert.ErrorIs(t, err, io.EOF)
 	assert.Nil(t, l3)
 }
+
+func TestGzCsvReaderFromReader(t *testing.T) {
+	f, err := os.Open("gz_csv_reader_test_data.csv.gz")
+	assert.NoError(t, err)
+	defer f.Close()
+	reader, err := NewGzCsvReaderFromReader(f)
+	assert.NoError(t, err)
+	l1, err := reader.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "id1", l1["id"])
+	l2, err := reader.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "id2", l2["id"])
+	_, err = reader.ReadLine()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestVerifyDataFile(t *testing.T) {
+	assert.NoError(t, VerifyDataFile("gz_csv_reader_test_data.csv.gz"))
+	data, err := ioutil.ReadFile("gz_csv_reader_test_data.csv.gz")
+	assert.NoError(t, err)
+	truncated := t.TempDir() + "/truncated.csv.gz"
+	assert.NoError(t, ioutil.WriteFile(truncated, data[:len(data)-4], 0644))
+	assert.Error(t, VerifyDataFile(truncated))
+}
+
+func TestGzCsvReader_RaggedRows(t *testing.T) {
+	data := "\ufeffid,last_active\nid1,2020-01-01 00:00:00,extra\nid2\nid3,2020-01-03 00:00:00\n"
+
+	var malformed []int
+	reader, err := NewGzCsvReaderFromReader(strings.NewReader(data), CsvOptions{
+		RaggedRows: RaggedRowsSkip,
+		OnMalformedRow: func(err *MalformedRowError) {
+			malformed = append(malformed, err.Line)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "last_active"}, reader.Header())
+	l, err := reader.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"id": "id3", "last_active": "2020-01-03 00:00:00"}, l)
+	assert.Equal(t, 2, reader.Skipped)
+	assert.Equal(t, []int{2, 3}, malformed)
+
+	reader, err = NewGzCsvReaderFromReader(strings.NewReader(data), CsvOptions{RaggedRows: RaggedRowsPad})
+	assert.NoError(t, err)
+	l, err = reader.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"id": "id1", "last_active": "2020-01-01 00:00:00"}, l)
+	l, err = reader.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"id": "id2", "last_active": ""}, l)
+
+	reader, err = NewGzCsvReaderFromReader(strings.NewReader(data), CsvOptions{RaggedRows: RaggedRowsPad, Strict: true})
+	assert.NoError(t, err)
+	_, err = reader.ReadLine()
+	var malformedErr *MalformedRowError
+	assert.ErrorAs(t, err, &malformedErr)
+	assert.Equal(t, 2, malformedErr.Line)
+}
+
+func TestGzCsvReader_RaggedRows_MultilineField(t *testing.T) {
+	data := "id,last_active,tags\nid1,2020-01-01 00:00:00,\"{\n}\"\nid2,2020-01-02 00:00:00\nid3,\"broken\n"
+
+	var malformed []int
+	reader, err := NewGzCsvReaderFromReader(strings.NewReader(data), CsvOptions{
+		OnMalformedRow: func(err *MalformedRowError) {
+			malformed = append(malformed, err.Line)
+		},
+	})
+	assert.NoError(t, err)
+	l, err := reader.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "id1", l["id"])
+	_, err = reader.ReadLine()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []int{4, 5}, malformed)
+}
+
+func TestGzCsvReader_Strict(t *testing.T) {
+	data := "id,last_active\nid1,2020-01-01 00:00:00\nid2,\"2020-01-02 \"00:00:00\"\nid3,2020-01-03 00:00:00\n"
+
+	reader, err := NewGzCsvReaderFromReader(strings.NewReader(data))
+	assert.NoError(t, err)
+	_, err = reader.ReadLine()
+	assert.NoError(t, err)
+	l, err := reader.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "id3", l["id"])
+
+	reader, err = NewGzCsvReaderFromReader(strings.NewReader(data), CsvOptions{Strict: true})
+	assert.NoError(t, err)
+	_, err = reader.ReadLine()
+	assert.NoError(t, err)
+	_, err = reader.ReadLine()
+	assert.EqualError(t, err, "malformed row at line 3: extraneous or missing \" in quoted-field")
+
+	reader, err = NewGzCsvReaderFromReader(strings.NewReader(data), CsvOptions{Strict: true, LazyQuotes: true})
+	assert.NoError(t, err)
+	_, err = reader.ReadLine()
+	assert.NoError(t, err)
+	l, err = reader.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "id2", l["id"])
+}
+
+func TestGzCsvReader_DuplicateHeaderColumns(t *testing.T) {
+	_, err := NewGzCsvReaderFromReader(strings.NewReader("id,last_active,id\nid1,2020-01-01 00:00:00,id1\n"))
+	assert.EqualError(t, err, "duplicate header column: id")
+}
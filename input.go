@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// StdinInput is a data file name meaning the data is read from stdin
+const StdinInput = "-"
+
+type RowReader interface {
+	ReadLine() (map[string]string, error)
+	Close()
+}
+
+type headerReader interface {
+	Header() []string
+}
+
+func isRemoteInput(input string) bool {
+	return strings.HasPrefix(input, "https://") || strings.HasPrefix(input, "http://")
+}
+
+// openInput opens a local file, stdin ("-") or a remote file (http(s)://...) fetched through Downloader
+func (c *Cleaner) openInput(input string) (RowReader, error) {
+	if input == StdinInput {
+		c.Logger.Infof("Reading data from stdin")
+		return c.GzCsvStreamReaderFactory(os.Stdin)
+	}
+	if isRemoteInput(input) {
+		c.Logger.WithField("url", input).Infof("Reading data from a remote file")
+		body, err := c.Downloader.Open(input)
+		if err != nil {
+			return nil, err
+		}
+		r, err := c.GzCsvStreamReaderFactory(body)
+		if err != nil {
+			_ = body.Close()
+			return nil, err
+		}
+		return &closingRowReader{GzCsvReader: r, closer: body}, nil
+	}
+	c.Logger.WithField("file", input).Infof("Reading data from a local file")
+	return c.GzCsvReaderFactory(input)
+}
+
+type closingRowReader struct {
+	*GzCsvReader
+	closer io.Closer
+}
+
+func (r *closingRowReader) Close() {
+	r.GzCsvReader.Close()
+	_ = r.closer.Close()
+}
+
+// mergeInputs reads all the inputs de-duplicating players by ID and keeping the most recent last_active,
+// all unique players are kept in memory.
+func (c *Cleaner) mergeInputs(inputs []string) (RowReader, error) {
+	var ids []string
+	rows := map[string]PlayerData{}
+	lastActive := map[string]time.Time{}
+	for _, input := range inputs {
+		r, err := c.openInput(input)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error while opening an input: %s", input)
+		}
+		if hr, ok := r.(headerReader); ok {
+			if err := c.validateHeader(hr.Header()); err != nil {
+				r.Close()
+				return nil, errors.Wrapf(err, "error while validating an input: %s", input)
+			}
+		}
+		duplicates := 0
+		for {
+			pd, err := r.ReadLine()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				r.Close()
+				return nil, errors.Wrapf(err, "error while reading an input: %s", input)
+			}
+			// A row with an invalid last_active is kept only if there is no other row of the player
+			p, _ := c.unmarshalPlayerData(pd)
+			id := pd["id"]
+			if _, ok := rows[id]; !ok {
+				ids = append(ids, id)
+			} else {
+				duplicates += 1
+				if !p.LastActive.After(lastActive[id]) {
+					continue
+				}
+			}
+			rows[id] = pd
+			lastActive[id] = p.LastActive
+		}
+		r.Close()
+		c.Logger.
+			WithField("input", input).
+			WithField("duplicates", duplicates).
+			Infof("Input has been read")
+	}
+	return &sliceRowReader{ids: ids, rows: rows}, nil
+}
+
+type sliceRowReader struct {
+	ids  []string
+	rows map[string]PlayerData
+}
+
+func (r *sliceRowReader) ReadLine() (map[string]string, error) {
+	if len(r.ids) == 0 {
+		return nil, io.EOF
+	}
+	pd := r.rows[r.ids[0]]
+	delete(r.rows, r.ids[0])
+	r.ids = r.ids[1:]
+	return pd, nil
+}
+
+func (r *sliceRowReader) Close() {
+	r.ids = nil
+	r.rows = nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"github.com/mingalevme/gologger"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+func writeGzCsv(t *testing.T, filename string, rows [][]string) {
+	f, err := os.Create(filename)
+	assert.NoError(t, err)
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	w := csv.NewWriter(gw)
+	assert.NoError(t, w.WriteAll(rows))
+	assert.NoError(t, gw.Close())
+}
+
+func TestCleaner_mergeInputs(t *testing.T) {
+	dir := t.TempDir()
+	writeGzCsv(t, dir+"/1.csv.gz", [][]string{
+		{"id", "last_active"},
+		{"id1", "2020-01-01 00:00:00"},
+		{"id2", "2021-01-01 00:00:00"},
+	})
+	writeGzCsv(t, dir+"/2.csv.gz", [][]string{
+		{"id", "last_active"},
+		{"id2", "2020-01-01 00:00:00"},
+		{"id1", "2021-06-01 00:00:00"},
+		{"id3", "2019-01-01 00:00:00"},
+	})
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	r, err := cleaner.mergeInputs([]string{dir + "/1.csv.gz", dir + "/2.csv.gz"})
+	assert.NoError(t, err)
+	defer r.Close()
+	var rows []map[string]string
+	for {
+		pd, err := r.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		rows = append(rows, pd)
+	}
+	assert.Equal(t, []map[string]string{
+		{"id": "id1", "last_active": "2021-06-01 00:00:00"},
+		{"id": "id2", "last_active": "2021-01-01 00:00:00"},
+		{"id": "id3", "last_active": "2019-01-01 00:00:00"},
+	}, rows)
+}
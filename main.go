@@ -1,11 +1,23 @@
 package main
 
 import (
+	"fmt"
 	"github.com/mingalevme/gologger"
+	"io"
 	"log"
+	"math/rand"
+	"net/http"
+	"onesignal-cleaner/fakeonesignal"
 	"os"
+	"strings"
+	"time"
+	// Embedded timezone database for images without one (e.g. alpine)
+	_ "time/tzdata"
+)
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
 )
-import "github.com/urfave/cli/v2"
 
 func main() {
 	app := &cli.App{
@@ -16,13 +28,20 @@ func main() {
 				Name: "app-id",
 				Usage: "OneSignal App ID",
 				EnvVars: []string{"ONESIGNAL_CLEANER_APP_ID"},
-				Required: true,
+				Required: false,
 			},
 			&cli.StringFlag{
 				Name: "rest-api-key",
 				Usage: "Rest API Key",
 				EnvVars: []string{"ONESIGNAL_CLEANER_REST_API_KEY"},
-				Required: true,
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "onesignal-origin",
+				Usage: "OneSignal API origin, e.g. a fake server for rehearsals",
+				EnvVars: []string{"ONESIGNAL_CLEANER_ONESIGNAL_ORIGIN"},
+				Value: OnesignalOrigin,
+				Required: false,
 			},
 			&cli.IntFlag{
 				Name: "inactive-for",
@@ -45,6 +64,48 @@ func main() {
 				Value: os.TempDir(),
 				Required: false,
 			},
+			&cli.StringFlag{
+				Name: "readiness-polling",
+				Usage: "Strategy of waiting for players data resource is ready: fixed, exponential or jittered",
+				EnvVars: []string{"ONESIGNAL_CLEANER_READINESS_POLLING"},
+				Value: "fixed",
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "readiness-poll-interval",
+				Usage: "Interval (initial one for exponential and jittered strategies) in seconds between readiness checks",
+				EnvVars: []string{"ONESIGNAL_CLEANER_READINESS_POLL_INTERVAL"},
+				Value: 5,
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "readiness-poll-max-interval",
+				Usage: "Max interval in seconds between readiness checks for exponential and jittered strategies",
+				EnvVars: []string{"ONESIGNAL_CLEANER_READINESS_POLL_MAX_INTERVAL"},
+				Value: 120,
+				Required: false,
+			},
+			&cli.IntSliceFlag{
+				Name: "not-ready-status",
+				Usage: "Response status code meaning players data resource is not ready yet, can be set multiple times",
+				EnvVars: []string{"ONESIGNAL_CLEANER_NOT_READY_STATUSES"},
+				Value: cli.NewIntSlice(403),
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "max-download-resumes",
+				Usage: "Max number of attempts to resume a broken data file downloading",
+				EnvVars: []string{"ONESIGNAL_CLEANER_MAX_DOWNLOAD_RESUMES"},
+				Value: 5,
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "verify-data-file",
+				Usage: "Verify a downloaded data file integrity before handling it",
+				EnvVars: []string{"ONESIGNAL_CLEANER_VERIFY_DATA_FILE"},
+				Value: true,
+				Required: false,
+			},
 			&cli.IntFlag{
 				Name: "concurrency",
 				Usage: "Max number of concurrent requests",
@@ -52,12 +113,68 @@ func main() {
 				Value: 5,
 				Required: false,
 			},
-			&cli.StringFlag{
+			&cli.IntFlag{
+				Name: "parse-workers",
+				Usage: "Number of goroutines parsing players data in the pipelined mode, 0 (default) handles players in a single goroutine",
+				EnvVars: []string{"ONESIGNAL_CLEANER_PARSE_WORKERS"},
+				Value: 0,
+				Required: false,
+			},
+			&cli.StringSliceFlag{
 				Name: "data-file",
-				Usage: "Read data from a local file (*.csv.gz) instead of requesting one from OneSignal",
+				Usage: "Read data from a local file (*.csv, *.csv.gz, *.csv.zst or *.csv.bz2), stdin (-) or an URL instead of requesting one from OneSignal, can be set multiple times, players are de-duplicated by ID",
 				EnvVars: []string{"ONESIGNAL_CLEANER_DATA_FILE"},
 				Required: false,
 			},
+			&cli.StringSliceFlag{
+				Name: "timestamp-layout",
+				Usage: "Go time layout of last_active, can be set multiple times, Unix epoch seconds/milliseconds are accepted if no layout matches",
+				EnvVars: []string{"ONESIGNAL_CLEANER_TIMESTAMP_LAYOUTS"},
+				Value: cli.NewStringSlice(OneSignalTimeLayout, time.RFC3339),
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "timestamp-timezone",
+				Usage: "Timezone (IANA name, e.g. Europe/Moscow) of last_active without an explicit offset",
+				EnvVars: []string{"ONESIGNAL_CLEANER_TIMESTAMP_TIMEZONE"},
+				Value: "UTC",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "future-last-active",
+				Usage: "How to handle a player with last_active in the future: active, skip or inactive",
+				EnvVars: []string{"ONESIGNAL_CLEANER_FUTURE_LAST_ACTIVE"},
+				Value: string(FutureLastActiveActive),
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "strict-csv",
+				Usage: "Fail on the first malformed row of a data file reporting its line number",
+				EnvVars: []string{"ONESIGNAL_CLEANER_STRICT_CSV"},
+				Value: false,
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "csv-ragged-rows",
+				Usage: "What to do with rows having a number of fields different from the header's one: skip, pad or error",
+				EnvVars: []string{"ONESIGNAL_CLEANER_CSV_RAGGED_ROWS"},
+				Value: string(RaggedRowsSkip),
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "csv-lazy-quotes",
+				Usage: "Allow a quote to appear in an unquoted field and a non-doubled quote to appear in a quoted field",
+				EnvVars: []string{"ONESIGNAL_CLEANER_CSV_LAZY_QUOTES"},
+				Value: false,
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "csv-fields-per-record",
+				Usage: "Exact number of fields per row (see encoding/csv), 0 checks it according to csv-ragged-rows",
+				EnvVars: []string{"ONESIGNAL_CLEANER_CSV_FIELDS_PER_RECORD"},
+				Value: 0,
+				Required: false,
+			},
 			&cli.BoolFlag{
 				Name: "download-only",
 				Usage: "Download data file only without handling it",
@@ -65,6 +182,239 @@ func main() {
 				Value: false,
 				Required: false,
 			},
+			&cli.StringFlag{
+				Name: "source",
+				Usage: "Where players are read from: export (CSV export), api (View devices API, for small apps) or auto (export falling back to api)",
+				EnvVars: []string{"ONESIGNAL_CLEANER_SOURCE"},
+				Value: string(SourceModeExport),
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "stream",
+				Usage: "Handle players while the data is being downloaded without storing it to a disk",
+				EnvVars: []string{"ONESIGNAL_CLEANER_STREAM"},
+				Value: false,
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "stream-archive",
+				Usage: "Store the data to tmp-dir while streaming it",
+				EnvVars: []string{"ONESIGNAL_CLEANER_STREAM_ARCHIVE"},
+				Value: false,
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "max-export-age",
+				Usage: "Reuse a data file previously downloaded into tmp-dir if it is not older than the value (in seconds), 0 disables reusing",
+				EnvVars: []string{"ONESIGNAL_CLEANER_MAX_EXPORT_AGE"},
+				Value: 0,
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "list-cached-exports",
+				Usage: "List data files previously downloaded into tmp-dir and exit",
+				EnvVars: []string{"ONESIGNAL_CLEANER_LIST_CACHED_EXPORTS"},
+				Value: false,
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "prune-cached-exports",
+				Usage: "Delete data files in tmp-dir older than max-export-age (required to be positive) and exit",
+				EnvVars: []string{"ONESIGNAL_CLEANER_PRUNE_CACHED_EXPORTS"},
+				Value: false,
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "delete-data-file",
+				Usage: "Delete a downloaded data file after a successful run",
+				EnvVars: []string{"ONESIGNAL_CLEANER_DELETE_DATA_FILE"},
+				Value: false,
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "keep-last-exports",
+				Usage: "Keep only the last N data files of the app in tmp-dir (files matching keep-exports-for are kept too), 0 disables the rule",
+				EnvVars: []string{"ONESIGNAL_CLEANER_KEEP_LAST_EXPORTS"},
+				Value: 0,
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "keep-exports-for",
+				Usage: "Keep data files of the app in tmp-dir not older than the value (in seconds), 0 disables the rule",
+				EnvVars: []string{"ONESIGNAL_CLEANER_KEEP_EXPORTS_FOR"},
+				Value: 0,
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "min-free-disk-space",
+				Usage: "Min free disk space (in megabytes) in tmp-dir required to start downloading a data file, 0 disables the check",
+				EnvVars: []string{"ONESIGNAL_CLEANER_MIN_FREE_DISK_SPACE"},
+				Value: 0,
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "s3-endpoint",
+				Usage: "Origin of an S3-compatible storage to upload data files and run reports to, e.g. https://s3.eu-west-1.amazonaws.com",
+				EnvVars: []string{"ONESIGNAL_CLEANER_S3_ENDPOINT"},
+				Value: "https://s3.amazonaws.com",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "s3-region",
+				Usage: "Region of the S3-compatible storage",
+				EnvVars: []string{"ONESIGNAL_CLEANER_S3_REGION"},
+				Value: "us-east-1",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "s3-bucket",
+				Usage: "Bucket of the S3-compatible storage, uploading is disabled if empty",
+				EnvVars: []string{"ONESIGNAL_CLEANER_S3_BUCKET"},
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "s3-prefix",
+				Usage: "Prefix of uploaded objects keys",
+				EnvVars: []string{"ONESIGNAL_CLEANER_S3_PREFIX"},
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "s3-access-key-id",
+				Usage: "Access key ID of the S3-compatible storage",
+				EnvVars: []string{"ONESIGNAL_CLEANER_S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"},
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "s3-secret-access-key",
+				Usage: "Secret access key of the S3-compatible storage",
+				EnvVars: []string{"ONESIGNAL_CLEANER_S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"},
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "s3-session-token",
+				Usage: "Session token of the S3-compatible storage",
+				EnvVars: []string{"ONESIGNAL_CLEANER_S3_SESSION_TOKEN", "AWS_SESSION_TOKEN"},
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "s3-sse",
+				Usage: "Server-side encryption of uploaded objects: AES256 or aws:kms",
+				EnvVars: []string{"ONESIGNAL_CLEANER_S3_SSE"},
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "s3-sse-kms-key-id",
+				Usage: "KMS key ID for aws:kms server-side encryption",
+				EnvVars: []string{"ONESIGNAL_CLEANER_S3_SSE_KMS_KEY_ID"},
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "s3-path-style",
+				Usage: "Use path-style addressing (endpoint/bucket/key) of the S3-compatible storage",
+				EnvVars: []string{"ONESIGNAL_CLEANER_S3_PATH_STYLE"},
+				Value: true,
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "progress",
+				Usage: "Progress reporting mode: auto, log, bar or none, auto shows a progress bar if stderr is a terminal",
+				EnvVars: []string{"ONESIGNAL_CLEANER_PROGRESS"},
+				Value: string(ProgressModeAuto),
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "progress-interval",
+				Usage: "Min interval in seconds between progress reports",
+				EnvVars: []string{"ONESIGNAL_CLEANER_PROGRESS_INTERVAL"},
+				Value: 10,
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "deletion-journal",
+				Usage: "CSV file successfully deleted players are appended to, e.g. to verify a cleanup with diff-command",
+				EnvVars: []string{"ONESIGNAL_CLEANER_DELETION_JOURNAL"},
+				Required: false,
+			},
+			&cli.Float64Flag{
+				Name: "verify-deletions",
+				Usage: "Ratio (0..1) of deleted players re-fetched after the cleaning to check they are really deleted, 0 disables the verification",
+				EnvVars: []string{"ONESIGNAL_CLEANER_VERIFY_DELETIONS"},
+				Value: 0,
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "verify-deletions-delay",
+				Usage: "Delay in seconds before the deletions verification",
+				EnvVars: []string{"ONESIGNAL_CLEANER_VERIFY_DELETIONS_DELAY"},
+				Value: 60,
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "retry-undeleted",
+				Usage: "Retry deletion of players still existing after the deletions verification",
+				EnvVars: []string{"ONESIGNAL_CLEANER_RETRY_UNDELETED"},
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name: "check-freshness",
+				Usage: "Fetch every inactive player before the deletion and skip it if it is not inactive according to the live last active time",
+				EnvVars: []string{"ONESIGNAL_CLEANER_CHECK_FRESHNESS"},
+				Required: false,
+			},
+			&cli.StringSliceFlag{
+				Name: "action",
+				Usage: "What is done with inactive players: delete, unsubscribe (notification_types=-2 and the stale tag), tag (the stale tag only), file (see --action-file) or webhook (see --webhook-url), can be set multiple times to chain actions, e.g. file,delete",
+				EnvVars: []string{"ONESIGNAL_CLEANER_ACTION"},
+				Value: cli.NewStringSlice(string(ActionModeDelete)),
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "action-file",
+				Usage: "File inactive players are appended to as JSON lines by file-action",
+				EnvVars: []string{"ONESIGNAL_CLEANER_ACTION_FILE"},
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "webhook-url",
+				Usage: "URL inactive players are posted to as JSON by webhook-action",
+				EnvVars: []string{"ONESIGNAL_CLEANER_WEBHOOK_URL"},
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "webhook-timeout",
+				Usage: "Timeout of a webhook request (in seconds), a timed out request is an error",
+				EnvVars: []string{"ONESIGNAL_CLEANER_WEBHOOK_TIMEOUT"},
+				Value: int(DefaultWebhookTimeout / time.Second),
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "target",
+				Usage: "What is deleted for an inactive player: player (legacy players API), subscription (User Model subscription of the same ID) or user (User Model user with all its subscriptions, see --user-alias)",
+				EnvVars: []string{"ONESIGNAL_CLEANER_TARGET"},
+				Value: string(DeletionTargetPlayer),
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "user-alias",
+				Usage: "Alias label users are deleted by with --target user, e.g. external_id or onesignal_id, its value is the data file column of the same name",
+				EnvVars: []string{"ONESIGNAL_CLEANER_USER_ALIAS"},
+				Value: AliasExternalId,
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name: "stale-tag",
+				Usage: "Tag soft-deleted players are marked with, its value is the date of marking",
+				EnvVars: []string{"ONESIGNAL_CLEANER_STALE_TAG"},
+				Value: DefaultStaleTag,
+				Required: false,
+			},
+			&cli.IntFlag{
+				Name: "grace-period",
+				Usage: "Time in seconds players marked with the stale tag are kept before the deletion, 0 never deletes them",
+				EnvVars: []string{"ONESIGNAL_CLEANER_GRACE_PERIOD"},
+				Value: 0,
+				Required: false,
+			},
 			&cli.BoolFlag{
 				Name: "debug",
 				Usage: "Sets logging level to debug",
@@ -72,7 +422,16 @@ func main() {
 				Required: false,
 			},
 		},
+		Commands: []*cli.Command{
+			fakeOneSignalCommand(),
+			generateCommand(),
+			analyzeCommand(),
+			diffCommand(),
+		},
 		Action: func(c *cli.Context) error {
+			if err := requireFlags(c, "app-id", "rest-api-key"); err != nil {
+				return err
+			}
 			lvl := gologger.LevelInfo
 			if c.Bool("debug") {
 				lvl = gologger.LevelDebug
@@ -80,6 +439,9 @@ func main() {
 			logger := gologger.NewStdoutLogger(lvl)
 			cleaner := NewCleaner(c.String("app-id"), c.String("rest-api-key"), logger)
 			cleaner.Logger = logger
+			if c.String("onesignal-origin") != "" {
+				cleaner.OneSignalClient.OriginUrl = strings.TrimRight(c.String("onesignal-origin"), "/")
+			}
 			if c.Int("inactive-for") > 0 {
 				cleaner.InactiveFor = c.Int("inactive-for")
 			}
@@ -89,9 +451,105 @@ func main() {
 			if c.Int("readiness-timeout") > 0 {
 				cleaner.Downloader.ReadinessTimeout = c.Int("readiness-timeout")
 			}
+			progressMode, err := ParseProgressMode(c.String("progress"))
+			if err != nil {
+				return err
+			}
+			cleaner.Progress.Mode = progressMode
+			if progressMode == ProgressModeBar {
+				// The bar is redrawn often enough to be smooth
+				cleaner.Progress.Interval = 200 * time.Millisecond
+			} else if c.Int("progress-interval") > 0 {
+				cleaner.Progress.Interval = time.Duration(c.Int("progress-interval")) * time.Second
+			}
+			if err := configureParsing(c, cleaner); err != nil {
+				return err
+			}
+			polling, err := NewPollingStrategy(
+				c.String("readiness-polling"),
+				time.Duration(c.Int("readiness-poll-interval"))*time.Second,
+				time.Duration(c.Int("readiness-poll-max-interval"))*time.Second,
+			)
+			if err != nil {
+				return err
+			}
+			cleaner.Downloader.Polling = polling
+			if len(c.IntSlice("not-ready-status")) > 0 {
+				cleaner.Downloader.NotReadyStatuses = c.IntSlice("not-ready-status")
+			}
+			if c.Int("max-download-resumes") >= 0 {
+				cleaner.Downloader.MaxResumes = c.Int("max-download-resumes")
+			}
+			cleaner.VerifyDataFile = c.Bool("verify-data-file")
 			if c.Int("concurrency") > 0 {
 				cleaner.Concurrency = c.Int("concurrency")
 			}
+			if c.Int("parse-workers") >= 0 {
+				cleaner.ParseWorkers = c.Int("parse-workers")
+			}
+			if c.Bool("stream") {
+				cleaner.Stream = true
+				cleaner.StreamArchive = c.Bool("stream-archive")
+			}
+			if c.Int("max-export-age") > 0 {
+				cleaner.MaxExportAge = c.Int("max-export-age")
+			}
+			if c.Bool("delete-data-file") {
+				cleaner.DeleteDataFile = true
+			}
+			if c.Int("keep-last-exports") > 0 {
+				cleaner.KeepLastExports = c.Int("keep-last-exports")
+			}
+			if c.Int("keep-exports-for") > 0 {
+				cleaner.KeepExportsFor = c.Int("keep-exports-for")
+			}
+			if c.Int("min-free-disk-space") > 0 {
+				cleaner.MinFreeDiskSpace = uint64(c.Int("min-free-disk-space")) * 1024 * 1024
+			}
+			if c.String("s3-bucket") != "" {
+				sink := NewS3Sink(c.String("s3-endpoint"), c.String("s3-region"), c.String("s3-bucket"))
+				sink.Prefix = c.String("s3-prefix")
+				sink.AccessKeyId = c.String("s3-access-key-id")
+				sink.SecretAccessKey = c.String("s3-secret-access-key")
+				sink.SessionToken = c.String("s3-session-token")
+				sink.ServerSideEncryption = c.String("s3-sse")
+				sink.SSEKMSKeyId = c.String("s3-sse-kms-key-id")
+				sink.PathStyle = c.Bool("s3-path-style")
+				sink.Logger = logger
+				cleaner.Sink = sink
+			}
+			if c.Bool("list-cached-exports") {
+				exports, err := cleaner.ExportCache().List()
+				if err != nil {
+					return err
+				}
+				for _, e := range exports {
+					fmt.Printf("%s\t%s\t%d\n", e.CreatedAt.Format(time.RFC3339), e.Filename, e.Size)
+				}
+				return nil
+			}
+			if c.Bool("prune-cached-exports") {
+				// max-export-age is 0 by default, pruning with it would delete all the data files
+				if cleaner.MaxExportAge <= 0 {
+					return fmt.Errorf("prune-cached-exports requires a positive max-export-age")
+				}
+				pruned, err := cleaner.ExportCache().Prune(cleaner.MaxExportAge)
+				if err != nil {
+					return err
+				}
+				logger.Infof("%d cached data file(s) have been deleted", len(pruned))
+				return nil
+			}
+			source, err := ParseSourceMode(c.String("source"))
+			if err != nil {
+				return err
+			}
+			if source != SourceModeExport {
+				if c.Bool("stream") || c.Bool("download-only") {
+					return fmt.Errorf("stream and download-only modes require the export source")
+				}
+				cleaner.Source = cleaner.NewPlayerSource(source)
+			}
 			if c.Bool("download-only") {
 				logger.Infof("Starting in \"download-only\"-mode")
 				cleaner.DownloadOnly = true
@@ -99,10 +557,52 @@ func main() {
 			logger.WithField("app-id", cleaner.OneSignalClient.AppId).
 				WithField("inactive-for", cleaner.InactiveFor).
 				WithField("concurrency", cleaner.Concurrency).
+				WithField("parse-workers", cleaner.ParseWorkers).
+				WithField("action", strings.Join(c.StringSlice("action"), ",")).
 				WithField("readiness-timeout", cleaner.Downloader.ReadinessTimeout).
 				WithField("tmp-dir", cleaner.TmpDir).
+				WithField("max-export-age", cleaner.MaxExportAge).
+				WithField("source", string(source)).
+				WithField("target", c.String("target")).
+				WithField("stream", cleaner.Stream).
 				Infof("OneSignal cleaning is starting ...")
-			err := cleaner.Clean(c.String("data-file"))
+			if c.Float64("verify-deletions") < 0 || c.Float64("verify-deletions") > 1 {
+				return fmt.Errorf("invalid verify-deletions ratio: %v", c.Float64("verify-deletions"))
+			}
+			cleaner.VerifyDeletions = c.Float64("verify-deletions")
+			cleaner.VerifyDeletionsDelay = time.Duration(c.Int("verify-deletions-delay")) * time.Second
+			cleaner.RetryUndeleted = c.Bool("retry-undeleted")
+			cleaner.CheckFreshness = c.Bool("check-freshness")
+			target, err := ParseDeletionTarget(c.String("target"))
+			if err != nil {
+				return err
+			}
+			cleaner.Target = target
+			if c.String("user-alias") != "" {
+				cleaner.UserAlias = c.String("user-alias")
+			}
+			closeActions, err := configureActions(c, cleaner)
+			if err != nil {
+				return err
+			}
+			defer closeActions()
+			if c.String("stale-tag") != "" {
+				cleaner.StaleTag = c.String("stale-tag")
+			}
+			if c.Int("grace-period") > 0 {
+				cleaner.GracePeriod = c.Int("grace-period")
+			}
+			if c.String("deletion-journal") != "" {
+				journal, err := OpenDeletionJournal(c.String("deletion-journal"))
+				if err != nil {
+					return err
+				}
+				defer func(journal *DeletionJournal) {
+					_ = journal.Close()
+				}(journal)
+				cleaner.Journal = journal
+			}
+			err = cleaner.Clean(c.StringSlice("data-file")...)
 			if err != nil {
 				logger.WithField("app-id", cleaner.OneSignalClient.AppId).
 					WithField("inactive-for", cleaner.InactiveFor).
@@ -121,3 +621,351 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// fakeOneSignalCommand runs a fake OneSignal server for local rehearsals
+func fakeOneSignalCommand() *cli.Command {
+	return &cli.Command{
+		Name: "fake-onesignal",
+		Usage: "Run a fake OneSignal API server seeded from a CSV export for local rehearsals",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name: "listen",
+				Usage: "Address to listen on",
+				Value: "127.0.0.1:8080",
+			},
+			&cli.StringFlag{
+				Name: "seed",
+				Usage: "CSV export (plain or gzipped) to seed players from",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name: "not-ready-responses",
+				Usage: "Number of 403-responses for an export before it becomes ready",
+				Value: 1,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := requireFlags(c, "app-id", "rest-api-key"); err != nil {
+				return err
+			}
+			logger := gologger.NewStdoutLogger(gologger.LevelInfo)
+			server := fakeonesignal.NewServer(c.String("app-id"), c.String("rest-api-key"))
+			server.NotReadyResponses = c.Int("not-ready-responses")
+			if err := server.SeedFile(c.String("seed")); err != nil {
+				return err
+			}
+			logger.WithField("listen", c.String("listen")).
+				WithField("players", len(server.Players())).
+				Infof("Fake OneSignal server is starting ...")
+			return http.ListenAndServe(c.String("listen"), server)
+		},
+	}
+}
+
+// generateCommand writes a synthetic export for load testing
+func generateCommand() *cli.Command {
+	return &cli.Command{
+		Name: "generate",
+		Usage: "Generate a synthetic export in OneSignal CSV export format for load testing",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name: "output",
+				Usage: "Output file (.csv.gz)",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name: "rows",
+				Usage: "Number of rows",
+				Value: 100000,
+			},
+			&cli.StringFlag{
+				Name: "last-active-ages",
+				Usage: "Distribution of last_active ages as comma separated max-age-in-days:weight-pairs",
+				Value: "30:40,180:20,365:15,1095:25",
+			},
+			&cli.StringFlag{
+				Name: "device-types",
+				Usage: "Mix of device types as comma separated device-type:weight-pairs",
+				Value: "0:40,1:55,5:5",
+			},
+			&cli.IntFlag{
+				Name: "tags",
+				Usage: "Number of tags per player",
+				Value: 2,
+			},
+			&cli.Float64Flag{
+				Name: "invalid-identifier-ratio",
+				Usage: "Ratio of players with invalid identifiers",
+				Value: 0.05,
+			},
+			&cli.Float64Flag{
+				Name: "malformed-ratio",
+				Usage: "Ratio of intentionally malformed rows",
+				Value: 0,
+			},
+			&cli.Int64Flag{
+				Name: "random-seed",
+				Usage: "Random seed to generate the same export, current time by default",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			logger := gologger.NewStdoutLogger(gologger.LevelInfo)
+			generator := NewExportGenerator(c.Int("rows"))
+			ages, err := ParseWeighted(c.String("last-active-ages"))
+			if err != nil {
+				return err
+			}
+			generator.LastActiveAges = ages
+			deviceTypes, err := ParseWeighted(c.String("device-types"))
+			if err != nil {
+				return err
+			}
+			generator.DeviceTypes = deviceTypes
+			generator.Tags = c.Int("tags")
+			generator.InvalidIdentifierRatio = c.Float64("invalid-identifier-ratio")
+			generator.MalformedRatio = c.Float64("malformed-ratio")
+			if c.Int64("random-seed") != 0 {
+				generator.Rand = rand.New(rand.NewSource(c.Int64("random-seed")))
+			}
+			stats, err := generator.GenerateFile(c.String("output"))
+			if err != nil {
+				return err
+			}
+			logger.WithField("output", c.String("output")).
+				WithField("rows", stats.Rows).
+				WithField("malformed", stats.Malformed).
+				WithField("invalid-identifier", stats.InvalidIdentifier).
+				WithField("last-active-ages", stats.LastActiveAges).
+				Infof("Synthetic export has been generated")
+			return nil
+		},
+	}
+}
+
+// requireFlags replaces app level Required flags, which would be required by all the commands
+func requireFlags(c *cli.Context, names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if c.String(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Required flags \"%s\" not set", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// configureParsing applies timestamps and CSV parsing flags
+func configureParsing(c *cli.Context, cleaner *Cleaner) error {
+	location, err := time.LoadLocation(c.String("timestamp-timezone"))
+	if err != nil {
+		return err
+	}
+	cleaner.Timestamps.Location = location
+	if len(c.StringSlice("timestamp-layout")) > 0 {
+		cleaner.Timestamps.Layouts = c.StringSlice("timestamp-layout")
+	}
+	futureLastActive, err := ParseFutureLastActivePolicy(c.String("future-last-active"))
+	if err != nil {
+		return err
+	}
+	cleaner.FutureLastActive = futureLastActive
+	raggedRows, err := ParseRaggedRowsPolicy(c.String("csv-ragged-rows"))
+	if err != nil {
+		return err
+	}
+	cleaner.CsvOptions.RaggedRows = raggedRows
+	cleaner.CsvOptions.Strict = c.Bool("strict-csv")
+	cleaner.CsvOptions.LazyQuotes = c.Bool("csv-lazy-quotes")
+	cleaner.CsvOptions.FieldsPerRecord = c.Int("csv-fields-per-record")
+	return nil
+}
+
+// configureActions builds the action chain from --action, the returned function closes the actions
+func configureActions(c *cli.Context, cleaner *Cleaner) (func(), error) {
+	var closers []io.Closer
+	closeActions := func() {
+		for _, closer := range closers {
+			_ = closer.Close()
+		}
+	}
+	var names []string
+	for _, value := range c.StringSlice("action") {
+		for _, name := range strings.Split(value, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	var actions []PlayerAction
+	for _, name := range names {
+		switch name {
+		case "file":
+			if err := requireFlags(c, "action-file"); err != nil {
+				closeActions()
+				return nil, err
+			}
+			action, err := OpenFileAction(c.String("action-file"), cleaner.OneSignalClient.AppId)
+			if err != nil {
+				closeActions()
+				return nil, err
+			}
+			closers = append(closers, action)
+			actions = append(actions, action)
+		case "webhook":
+			if err := requireFlags(c, "webhook-url"); err != nil {
+				closeActions()
+				return nil, err
+			}
+			if c.Int("webhook-timeout") <= 0 {
+				closeActions()
+				return nil, fmt.Errorf("webhook-action requires a positive webhook-timeout")
+			}
+			webhook := NewWebhookAction(c.String("webhook-url"), cleaner.OneSignalClient.AppId)
+			webhook.AppHttpClient = &http.Client{Timeout: time.Duration(c.Int("webhook-timeout")) * time.Second}
+			actions = append(actions, webhook)
+		default:
+			mode, err := ParseActionMode(name)
+			if err != nil {
+				closeActions()
+				return nil, err
+			}
+			if mode == ActionModeDelete {
+				actions = append(actions, NewDeleteAction(cleaner))
+			} else {
+				actions = append(actions, NewStaleAction(cleaner, mode == ActionModeUnsubscribe))
+			}
+		}
+	}
+	cleaner.Actions = actions
+	return closeActions, nil
+}
+
+// analyzeCommand reports players distribution of an export without deleting anything
+func analyzeCommand() *cli.Command {
+	return &cli.Command{
+		Name: "analyze",
+		Usage: "Report players counts by last active age, device type, country and invalid identifier and the number of players candidate thresholds would delete",
+		ArgsUsage: "data-file [data-file...]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name: "format",
+				Usage: "Output format: text, json or csv",
+				Value: string(AnalysisFormatText),
+			},
+			&cli.IntSliceFlag{
+				Name: "age-bucket",
+				Usage: "Upper bound of a last active age bucket in days, can be set multiple times",
+				Value: cli.NewIntSlice(7, 30, 90, 180, 365, 730),
+			},
+			&cli.IntSliceFlag{
+				Name: "threshold",
+				Usage: "Candidate --inactive-for value in seconds, can be set multiple times",
+				Value: cli.NewIntSlice(86400*30, 86400*90, 86400*180, 86400*365, 86400*730),
+			},
+		},
+		Action: func(c *cli.Context) error {
+			format, err := ParseAnalysisFormat(c.String("format"))
+			if err != nil {
+				return err
+			}
+			lvl := gologger.LevelInfo
+			if c.Bool("debug") {
+				lvl = gologger.LevelDebug
+			}
+			// stdout is reserved for the analysis
+			logger := newStderrLogger(lvl)
+			cleaner := NewCleaner(c.String("app-id"), c.String("rest-api-key"), logger)
+			cleaner.Progress.Mode = ProgressModeNone
+			if err := configureParsing(c, cleaner); err != nil {
+				return err
+			}
+			analyzer := NewAnalyzer()
+			analyzer.AgeBuckets = c.IntSlice("age-bucket")
+			analyzer.Thresholds = c.IntSlice("threshold")
+			analyzer.Timestamps = cleaner.Timestamps
+			analysis, err := cleaner.Analyze(analyzer, c.Args().Slice()...)
+			if err != nil {
+				return err
+			}
+			return WriteAnalysis(os.Stdout, analysis, format)
+		},
+	}
+}
+
+// diffCommand compares two exports and cross-checks deletions
+func diffCommand() *cli.Command {
+	return &cli.Command{
+		Name: "diff",
+		Usage: "Compare two exports by player ID reporting added, removed and changed last active players and deletions not taken effect",
+		ArgsUsage: "old-data-file new-data-file",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name: "format",
+				Usage: "Output format: text, json or csv",
+				Value: string(AnalysisFormatText),
+			},
+			&cli.StringSliceFlag{
+				Name: "deleted",
+				Usage: "Deletion journal (see --deletion-journal) or a file with a deleted player ID per line, can be set multiple times",
+			},
+			&cli.StringFlag{
+				Name: "new-exported-at",
+				Usage: "Time the new export has been taken at (see --timestamp-layout), players deleted later are not cross-checked, taken from the name of a cached data file by default",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() != 2 {
+				return fmt.Errorf("exactly two data files are expected, %d given", c.Args().Len())
+			}
+			format, err := ParseAnalysisFormat(c.String("format"))
+			if err != nil {
+				return err
+			}
+			lvl := gologger.LevelInfo
+			if c.Bool("debug") {
+				lvl = gologger.LevelDebug
+			}
+			// stdout is reserved for the diff
+			logger := newStderrLogger(lvl)
+			cleaner := NewCleaner(c.String("app-id"), c.String("rest-api-key"), logger)
+			cleaner.Progress.Mode = ProgressModeNone
+			if err := configureParsing(c, cleaner); err != nil {
+				return err
+			}
+			deleted := map[string]DeletedPlayer{}
+			for _, filename := range c.StringSlice("deleted") {
+				players, err := ReadDeletedPlayers(filename)
+				if err != nil {
+					return err
+				}
+				for id, p := range players {
+					deleted[id] = p
+				}
+			}
+			var newExportedAt time.Time
+			if v := c.String("new-exported-at"); v != "" {
+				if newExportedAt, err = cleaner.Timestamps.Parse(v); err != nil {
+					return fmt.Errorf("invalid new-exported-at: %v", err)
+				}
+			} else if _, createdAt, ok := parseExportFileName(c.Args().Get(1)); ok {
+				newExportedAt = createdAt
+			} else if len(deleted) > 0 {
+				logger.Warningf("Time of the new export is unknown, deleted players are cross-checked regardless of deletion time")
+			}
+			diff, err := cleaner.Diff(c.Args().Get(0), c.Args().Get(1), deleted, newExportedAt)
+			if err != nil {
+				return err
+			}
+			return WriteDiff(os.Stdout, diff, format)
+		},
+	}
+}
+
+func newStderrLogger(lvl gologger.Level) gologger.Logger {
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	if level, err := logrus.ParseLevel(lvl.String()); err == nil {
+		logger.SetLevel(level)
+	}
+	return gologger.NewLogrusLogger(logger)
+}
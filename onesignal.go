@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"github.com/mingalevme/gologger"
 	"github.com/pkg/errors"
@@ -8,10 +9,52 @@ import (
 	"io/ioutil"
 	"net/http"
 	urllib "net/url"
+	"strconv"
+	"strings"
 )
 
 const OnesignalOrigin = "https://onesignal.com"
 
+// ErrPlayerNotFound is returned by GetPlayer if the player does not exist
+var ErrPlayerNotFound = errors.New("player not found")
+
+const playerNotFoundMessage = "No user with this id found"
+
+// ErrSubscriptionNotFound is returned by DeleteSubscription if the subscription does not exist
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// ErrUserNotFound is returned by DeleteUser if the user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// OneSignalPlayer is a player (device) of View device response
+type OneSignalPlayer struct {
+	Id                string                 `json:"id"`
+	Identifier        string                 `json:"identifier"`
+	SessionCount      int                    `json:"session_count"`
+	Language          string                 `json:"language"`
+	Timezone          int                    `json:"timezone"`
+	GameVersion       string                 `json:"game_version"`
+	DeviceOs          string                 `json:"device_os"`
+	DeviceType        int                    `json:"device_type"`
+	DeviceModel       string                 `json:"device_model"`
+	AdId              string                 `json:"ad_id"`
+	Tags              map[string]interface{} `json:"tags"`
+	LastActive        int64                  `json:"last_active"`
+	Playtime          int                    `json:"playtime"`
+	CreatedAt         int64                  `json:"created_at"`
+	InvalidIdentifier bool                   `json:"invalid_identifier"`
+	BadgeCount        int                    `json:"badge_count"`
+	ExternalUserId    string                 `json:"external_user_id"`
+}
+
+// PlayersPage is a View devices response
+type PlayersPage struct {
+	TotalCount int                      `json:"total_count"`
+	Offset     int                      `json:"offset"`
+	Limit      int                      `json:"limit"`
+	Players    []map[string]interface{} `json:"players"`
+}
+
 type OneSignalClient struct {
 	OriginUrl     string
 	AppId         string
@@ -84,6 +127,119 @@ func (c *OneSignalClient) DeletePlayer(id string) error {
 	return nil
 }
 
+// GetPlayer returns ErrPlayerNotFound if the player does not exist
+func (c *OneSignalClient) GetPlayer(id string) (OneSignalPlayer, error) {
+	req := c.createGetPlayerRequest(id)
+	res, err := c.AppHttpClient.Do(req)
+	if err != nil {
+		return OneSignalPlayer{}, errors.Wrapf(err, "error while requesting a player: %s", id)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	if res.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(res.Body)
+		// {"errors": ["No user with this id found"]}, the status code is 400 for some of apps
+		if res.StatusCode == http.StatusNotFound || (res.StatusCode == http.StatusBadRequest && strings.Contains(string(body), playerNotFoundMessage)) {
+			return OneSignalPlayer{}, ErrPlayerNotFound
+		}
+		return OneSignalPlayer{}, errors.Errorf("error response (code: %d) while requesting a player (%s): %s", res.StatusCode, id, string(body))
+	}
+	var player OneSignalPlayer
+	err = json.NewDecoder(res.Body).Decode(&player)
+	if err != nil {
+		return OneSignalPlayer{}, errors.Wrapf(err, "error while decoding a json-body while requesting a player: %s", id)
+	}
+	return player, nil
+}
+
+// EditPlayer updates the player fields, e.g. {"notification_types": -2} or {"tags": {"key": "value"}}
+func (c *OneSignalClient) EditPlayer(id string, fields map[string]interface{}) error {
+	req, err := c.createEditPlayerRequest(id, fields)
+	if err != nil {
+		return errors.Wrapf(err, "error while creating a player editing request: %s", id)
+	}
+	res, err := c.AppHttpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error while requesting a player editing: %s", id)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	if res.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.Errorf("error response (code: %d) while requesting a player editing (%s): %s", res.StatusCode, id, string(body))
+	}
+	// {'success': true}
+	var body struct {
+		Success bool `json:"success"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return errors.Wrapf(err, "error while decoding a json-body while requesting a player editing: %s", id)
+	}
+	if !body.Success {
+		return errors.Errorf("error response payload while requesting a player editing (%s): %v", id, body)
+	}
+	return nil
+}
+
+// ViewPlayers returns a page of players (View devices), players are kept as decoded JSON objects
+// (numbers as json.Number), so all the fields are preserved.
+func (c *OneSignalClient) ViewPlayers(limit int, offset int) (PlayersPage, error) {
+	req := c.createViewPlayersRequest(limit, offset)
+	res, err := c.AppHttpClient.Do(req)
+	if err != nil {
+		return PlayersPage{}, errors.Wrapf(err, "error while requesting players (offset: %d)", offset)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	if res.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return PlayersPage{}, errors.Errorf("error response (code: %d) while requesting players (offset: %d): %s", res.StatusCode, offset, string(body))
+	}
+	var page PlayersPage
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&page)
+	if err != nil {
+		return PlayersPage{}, errors.Wrapf(err, "error while decoding a json-body while requesting players (offset: %d)", offset)
+	}
+	return page, nil
+}
+
+// DeleteSubscription deletes the subscription of User Model, a subscription ID equals to the ID of the legacy player
+func (c *OneSignalClient) DeleteSubscription(id string) error {
+	req := c.createDeleteSubscriptionRequest(id)
+	return c.doUserModelDeletion(req, ErrSubscriptionNotFound, "subscription "+id)
+}
+
+// DeleteUser deletes the user of User Model with all its subscriptions by the alias, e.g. external_id or onesignal_id
+func (c *OneSignalClient) DeleteUser(aliasLabel string, aliasId string) error {
+	req := c.createDeleteUserRequest(aliasLabel, aliasId)
+	return c.doUserModelDeletion(req, ErrUserNotFound, "user "+aliasLabel+"="+aliasId)
+}
+
+func (c *OneSignalClient) doUserModelDeletion(req *http.Request, notFound error, subject string) error {
+	res, err := c.AppHttpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error while requesting a deletion: %s", subject)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	// User Model endpoints respond 200 or 202 (the deletion is asynchronous) with an empty object
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return notFound
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	return errors.Errorf("error response (code: %d) while requesting a deletion (%s): %s", res.StatusCode, subject, string(body))
+}
+
 func (c *OneSignalClient) createGetExportRequest() *http.Request {
 	return c.createRequest(http.MethodPost, "/api/v1/players/csv_export")
 }
@@ -92,6 +248,46 @@ func (c *OneSignalClient) createDeletePlayerRequest(id string) *http.Request {
 	return c.createRequest(http.MethodDelete, "/api/v1/players/"+id)
 }
 
+func (c *OneSignalClient) createViewPlayersRequest(limit int, offset int) *http.Request {
+	req := c.createRequest(http.MethodGet, "/api/v1/players")
+	q := req.URL.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+	req.URL.RawQuery = q.Encode()
+	return req
+}
+
+func (c *OneSignalClient) createDeleteSubscriptionRequest(id string) *http.Request {
+	return c.createRequest(http.MethodDelete, "/api/v1/apps/"+urllib.PathEscape(c.AppId)+"/subscriptions/"+urllib.PathEscape(id))
+}
+
+func (c *OneSignalClient) createDeleteUserRequest(aliasLabel string, aliasId string) *http.Request {
+	return c.createRequest(http.MethodDelete, "/api/v1/apps/"+urllib.PathEscape(c.AppId)+"/users/by/"+urllib.PathEscape(aliasLabel)+"/"+urllib.PathEscape(aliasId))
+}
+
+func (c *OneSignalClient) createGetPlayerRequest(id string) *http.Request {
+	return c.createRequest(http.MethodGet, "/api/v1/players/"+id)
+}
+
+func (c *OneSignalClient) createEditPlayerRequest(id string, fields map[string]interface{}) (*http.Request, error) {
+	payload := map[string]interface{}{}
+	for k, v := range fields {
+		payload[k] = v
+	}
+	payload["app_id"] = c.AppId
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req := c.createRequest(http.MethodPut, "/api/v1/players/"+id)
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return req, nil
+}
+
 func (c *OneSignalClient) createRequest(method string, path string) *http.Request {
 	endpointUrl, err := urllib.Parse(c.OriginUrl + path)
 	if err != nil {
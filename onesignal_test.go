@@ -56,3 +56,123 @@ func TestOneSignalClient_DeletePlayer(t *testing.T) {
 	err := oneSignalClient.DeletePlayer(playerId)
 	assert.NoError(t, err)
 }
+
+func TestOneSignalClient_GetPlayer(t *testing.T) {
+	responses := []struct {
+		status int
+		body   string
+		err    error
+	}{
+		{200, `{"id":"some-player-id","identifier":"token","session_count":3,"device_type":1,"tags":{"level":"5"},"last_active":1600000000,"invalid_identifier":false}`, nil},
+		{404, `{"errors":["No user with this id found"]}`, ErrPlayerNotFound},
+		{400, `{"errors":["No user with this id found"]}`, ErrPlayerNotFound},
+		{500, `{"errors":["Internal Server Error"]}`, nil},
+	}
+	for i, r := range responses {
+		appHttpClient := &TestAppHttpClient{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				assert.Equal(t, http.MethodGet, req.Method)
+				assert.Equal(t, TestOnesignalOrigin+"/api/v1/players/some-player-id?app_id=appId", req.URL.String())
+				assert.Equal(t, "Basic restApiKey", req.Header.Get("Authorization"))
+				return &http.Response{
+					StatusCode: r.status,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(r.body)),
+					Request:    req,
+				}, nil
+			},
+		}
+		oneSignalClient := NewOneSignalClient("appId", "restApiKey")
+		oneSignalClient.OriginUrl = TestOnesignalOrigin
+		oneSignalClient.AppHttpClient = appHttpClient
+		oneSignalClient.Logger = gologger.NewNullLogger()
+		player, err := oneSignalClient.GetPlayer("some-player-id")
+		switch {
+		case r.status == 200:
+			assert.NoError(t, err, i)
+			assert.Equal(t, "some-player-id", player.Id)
+			assert.Equal(t, 3, player.SessionCount)
+			assert.Equal(t, int64(1600000000), player.LastActive)
+			assert.Equal(t, map[string]interface{}{"level": "5"}, player.Tags)
+		case r.err != nil:
+			assert.Equal(t, r.err, err, i)
+		default:
+			assert.Error(t, err, i)
+			assert.NotEqual(t, ErrPlayerNotFound, err, i)
+		}
+	}
+}
+
+func TestOneSignalClient_EditPlayer(t *testing.T) {
+	appHttpClient := &TestAppHttpClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, http.MethodPut, req.Method)
+			assert.Equal(t, TestOnesignalOrigin+"/api/v1/players/some-player-id?app_id=appId", req.URL.String())
+			assert.Equal(t, "Basic restApiKey", req.Header.Get("Authorization"))
+			assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+			body, err := ioutil.ReadAll(req.Body)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"app_id":"appId","notification_types":-2,"tags":{"stale_since":"2022-01-01"}}`, string(body))
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("{\"success\": true}")),
+				Request:    req,
+			}, nil
+		},
+	}
+	oneSignalClient := NewOneSignalClient("appId", "restApiKey")
+	oneSignalClient.OriginUrl = TestOnesignalOrigin
+	oneSignalClient.AppHttpClient = appHttpClient
+	oneSignalClient.Logger = gologger.NewNullLogger()
+	err := oneSignalClient.EditPlayer("some-player-id", map[string]interface{}{
+		"notification_types": -2,
+		"tags":               map[string]string{"stale_since": "2022-01-01"},
+	})
+	assert.NoError(t, err)
+}
+
+func TestOneSignalClient_DeleteSubscription(t *testing.T) {
+	status := http.StatusAccepted
+	appHttpClient := &TestAppHttpClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, http.MethodDelete, req.Method)
+			assert.Equal(t, TestOnesignalOrigin+"/api/v1/apps/appId/subscriptions/subscription-id?app_id=appId", req.URL.String())
+			assert.Equal(t, "Basic restApiKey", req.Header.Get("Authorization"))
+			return &http.Response{
+				StatusCode: status,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+				Request:    req,
+			}, nil
+		},
+	}
+	oneSignalClient := NewOneSignalClient("appId", "restApiKey")
+	oneSignalClient.OriginUrl = TestOnesignalOrigin
+	oneSignalClient.AppHttpClient = appHttpClient
+	assert.NoError(t, oneSignalClient.DeleteSubscription("subscription-id"))
+	status = http.StatusNotFound
+	assert.Equal(t, ErrSubscriptionNotFound, oneSignalClient.DeleteSubscription("subscription-id"))
+	status = http.StatusTooManyRequests
+	err := oneSignalClient.DeleteSubscription("subscription-id")
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrSubscriptionNotFound, err)
+}
+
+func TestOneSignalClient_DeleteUser(t *testing.T) {
+	status := http.StatusOK
+	appHttpClient := &TestAppHttpClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, http.MethodDelete, req.Method)
+			assert.Equal(t, TestOnesignalOrigin+"/api/v1/apps/appId/users/by/external_id/user%2F1?app_id=appId", req.URL.String())
+			return &http.Response{
+				StatusCode: status,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+				Request:    req,
+			}, nil
+		},
+	}
+	oneSignalClient := NewOneSignalClient("appId", "restApiKey")
+	oneSignalClient.OriginUrl = TestOnesignalOrigin
+	oneSignalClient.AppHttpClient = appHttpClient
+	assert.NoError(t, oneSignalClient.DeleteUser("external_id", "user/1"))
+	status = http.StatusNotFound
+	assert.Equal(t, ErrUserNotFound, oneSignalClient.DeleteUser("external_id", "user/1"))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+const (
+	pipelineBatchSize   = 256
+	pipelineQueueLength = 64
+)
+
+// handlePlayersPipeline splits players handling into stages connected by bounded channels:
+// decompression (see asyncReader), CSV decoding, parsing and policy evaluation (ParseWorkers goroutines)
+// and deletion (Concurrency goroutines). Rows are passed in batches to reduce synchronization costs.
+func (c *Cleaner) handlePlayersPipeline(r RowReader) (ProcessingProgress, error) {
+	c.Logger.WithField("parse-workers", c.ParseWorkers).Infof("Starting players handling pipeline ...")
+	stats := NewProcessingProgress()
+	rows := make(chan []PlayerData, pipelineQueueLength)
+	players := make(chan Player, pipelineQueueLength*pipelineBatchSize)
+	users := c.newUserGroups()
+
+	var readErr error
+	go func() {
+		defer close(rows)
+		batch := make([]PlayerData, 0, pipelineBatchSize)
+		for i := 1; ; i++ {
+			pd, err := r.ReadLine()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				readErr = errors.Wrapf(err, "error reading line #%d", i)
+				return
+			}
+			stats.AddRow()
+			batch = append(batch, pd)
+			if len(batch) < pipelineBatchSize {
+				continue
+			}
+			rows <- batch
+			batch = make([]PlayerData, 0, pipelineBatchSize)
+		}
+		if len(batch) > 0 {
+			rows <- batch
+		}
+	}()
+
+	parsers := sync.WaitGroup{}
+	for w := 0; w < c.ParseWorkers; w++ {
+		parsers.Add(1)
+		go func() {
+			defer parsers.Done()
+			for batch := range rows {
+				for _, pd := range batch {
+					p, ok := c.evaluatePlayerData(pd, stats)
+					if !ok {
+						users.protect(pd)
+						continue
+					}
+					if users.postpone(p) {
+						continue
+					}
+					players <- p
+				}
+				c.Progress.Report("Handling players", stats)
+			}
+		}()
+	}
+	go func() {
+		parsers.Wait()
+		close(players)
+	}()
+
+	deleters := sync.WaitGroup{}
+	for w := 0; w < c.Concurrency; w++ {
+		deleters.Add(1)
+		go func() {
+			defer deleters.Done()
+			for p := range players {
+				c.handleInactivePlayer(p, stats)
+			}
+		}()
+	}
+	deleters.Wait()
+	// readErr is set before rows channel is closed, so it is safe to read it after all the stages are finished
+	if readErr != nil {
+		c.Progress.Done("Players handling has been interrupted", stats)
+		return stats.Snapshot(), readErr
+	}
+	c.handleUserGroups(users, stats)
+	c.Progress.Done("Players have been handled", stats)
+	return stats.Snapshot(), nil
+}
+
+// evaluatePlayerData unmarshals the player data and returns the player if it is inactive
+func (c *Cleaner) evaluatePlayerData(pd PlayerData, stats *ProcessingProgress) (Player, bool) {
+	p, err := c.unmarshalPlayerData(pd)
+	if err != nil {
+		stats.AddError()
+		c.Logger.
+			WithField("id", pd["id"]).
+			WithField("last-active", pd["last_active"]).
+			WithError(err).
+			Errorf("Error while unmarshalling a player data")
+		return Player{}, false
+	}
+	inactive, err := c.isInactive(p)
+	if err != nil {
+		stats.AddError()
+		c.Logger.
+			WithField("id", p.Id).
+			WithField("last-active", p.LastActive.String()).
+			WithError(err).
+			Errorf("Player has been skipped")
+		return Player{}, false
+	}
+	if !inactive {
+		c.Logger.
+			WithField("id", p.Id).
+			WithField("last-active", p.LastActive.String()).
+			Infof("Player is active")
+		return Player{}, false
+	}
+	stats.AddInactive()
+	c.Logger.
+		WithField("id", p.Id).
+		WithField("last-active", p.LastActive.String()).
+		Debugf("Player is inactive")
+	return p, true
+}
+
+// asyncReader reads the underlying reader ahead in a separate goroutine,
+// e.g. to decompress data concurrently with CSV decoding
+type asyncReader struct {
+	chunks   chan []byte
+	errs     chan error
+	done     chan struct{}
+	finished chan struct{}
+	current  []byte
+	err      error
+}
+
+func newAsyncReader(r io.Reader, chunkSize int, depth int) *asyncReader {
+	ar := &asyncReader{
+		chunks:   make(chan []byte, depth),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go func() {
+		defer close(ar.finished)
+		defer close(ar.chunks)
+		for {
+			// Not io.ReadFull: it would turn io.ErrUnexpectedEOF of a truncated stream into a short chunk
+			chunk := make([]byte, chunkSize)
+			n := 0
+			var err error
+			for n < chunkSize && err == nil {
+				var m int
+				m, err = r.Read(chunk[n:])
+				n += m
+			}
+			if n > 0 {
+				select {
+				case ar.chunks <- chunk[:n]:
+				case <-ar.done:
+					return
+				}
+			}
+			if err != nil {
+				ar.errs <- err
+				return
+			}
+		}
+	}()
+	return ar
+}
+
+func (r *asyncReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		chunk, ok := <-r.chunks
+		if !ok {
+			r.err = <-r.errs
+			continue
+		}
+		r.current = chunk
+	}
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+// Close stops reading ahead and waits for the pending read, so the underlying reader can be closed after it,
+// it does not close the underlying reader
+func (r *asyncReader) Close() {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	<-r.finished
+}
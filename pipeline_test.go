@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"github.com/mingalevme/gologger"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeSyntheticExport writes an export of n players, every second player is inactive (last active a year ago)
+func writeSyntheticExport(tb testing.TB, filename string, n int, now time.Time) {
+	f, err := os.Create(filename)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	w := csv.NewWriter(gw)
+	_ = w.Write(OneSignalExportColumns)
+	row := make([]string, len(OneSignalExportColumns))
+	for i := 0; i < n; i++ {
+		lastActive := now.Add(-time.Hour)
+		if i%2 == 0 {
+			lastActive = now.AddDate(-1, 0, 0)
+		}
+		for j, column := range OneSignalExportColumns {
+			switch column {
+			case "id":
+				row[j] = fmt.Sprintf("%08d-0000-0000-0000-000000000000", i)
+			case "last_active", "created_at":
+				row[j] = lastActive.UTC().Format(OneSignalTimeLayout)
+			case "session_count", "badge_count", "playtime", "device_type", "invalid_identifier":
+				row[j] = "1"
+			case "tags":
+				row[j] = `{"level":"10"}`
+			default:
+				row[j] = column
+			}
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func newSyntheticCleaner(now time.Time, deleted *int64, mu *sync.Mutex) *Cleaner {
+	logger := gologger.NewNullLogger()
+	cleaner := NewCleaner("app-id", "rest-api-key", logger)
+	cleaner.OneSignalClient.AppHttpClient = &TestAppHttpClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			*deleted++
+			mu.Unlock()
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(strings.NewReader("{\"success\":true}")),
+			}, nil
+		},
+	}
+	cleaner.Progress.Mode = ProgressModeNone
+	cleaner.InactiveFor = 86400 * 30
+	cleaner.Concurrency = 10
+	cleaner.Now = func() int {
+		return int(now.Unix())
+	}
+	return cleaner
+}
+
+func TestCleaner_handlePlayersPipeline(t *testing.T) {
+	now := time.Now()
+	filename := t.TempDir() + "/export.csv.gz"
+	writeSyntheticExport(t, filename, 1000, now)
+
+	for _, workers := range []int{0, 1, 4} {
+		var deleted int64
+		cleaner := newSyntheticCleaner(now, &deleted, &sync.Mutex{})
+		cleaner.ParseWorkers = workers
+		r, err := cleaner.GzCsvReaderFactory(filename)
+		assert.NoError(t, err)
+		stats, err := cleaner.handlePlayers(r)
+		r.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1000), stats.Rows, "workers: %d", workers)
+		assert.Equal(t, int64(500), stats.Inactive, "workers: %d", workers)
+		assert.Equal(t, int64(500), stats.Deleted, "workers: %d", workers)
+		assert.Equal(t, int64(0), stats.Errors, "workers: %d", workers)
+		assert.Equal(t, int64(500), deleted, "workers: %d", workers)
+	}
+}
+
+func TestCleaner_handlePlayersPipeline_ReadError(t *testing.T) {
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.CsvOptions.Strict = true
+	cleaner.ParseWorkers = 2
+	r, err := cleaner.GzCsvStreamReaderFactory(strings.NewReader("id,last_active\nid1,2020-01-01 00:00:00\nid2,\"broken\n"))
+	assert.NoError(t, err)
+	defer r.Close()
+	_, err = cleaner.handlePlayers(r)
+	assert.Error(t, err)
+}
+
+func TestAsyncReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	r := newAsyncReader(bytes.NewReader(data), 64, 4)
+	read, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data, read)
+	n, err := r.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+	r.Close()
+	r.Close()
+}
+
+func TestAsyncReader_Truncated(t *testing.T) {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	_, _ = gw.Write(bytes.Repeat([]byte("0123456789"), 10000))
+	assert.NoError(t, gw.Close())
+	truncated := buf.Bytes()[:buf.Len()/2]
+
+	gr, err := gzip.NewReader(bytes.NewReader(truncated))
+	assert.NoError(t, err)
+	r := newAsyncReader(gr, 64, 4)
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func benchmarkCleanerHandlePlayers(b *testing.B, workers int) {
+	const rows = 100000
+	now := time.Now()
+	filename := b.TempDir() + "/export.csv.gz"
+	writeSyntheticExport(b, filename, rows, now)
+	var deleted int64
+	cleaner := newSyntheticCleaner(now, &deleted, &sync.Mutex{})
+	cleaner.ParseWorkers = workers
+	b.ResetTimer()
+	startedAt := time.Now()
+	for i := 0; i < b.N; i++ {
+		r, err := cleaner.GzCsvReaderFactory(filename)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = cleaner.handlePlayers(r); err != nil {
+			b.Fatal(err)
+		}
+		r.Close()
+	}
+	b.ReportMetric(float64(rows*b.N)/time.Since(startedAt).Seconds(), "rows/s")
+}
+
+// go test -tags testing -run '^$' -bench CleanerHandlePlayers .
+func BenchmarkCleanerHandlePlayers_Sequential(b *testing.B) {
+	benchmarkCleanerHandlePlayers(b, 0)
+}
+
+func BenchmarkCleanerHandlePlayers_Pipeline2(b *testing.B) {
+	benchmarkCleanerHandlePlayers(b, 2)
+}
+
+func BenchmarkCleanerHandlePlayers_Pipeline4(b *testing.B) {
+	benchmarkCleanerHandlePlayers(b, 4)
+}
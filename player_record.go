@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const OneSignalTimeLayout = "2006-01-02 15:04:05"
+
+// PlayerRecord is a typed row of a OneSignal CSV export
+type PlayerRecord struct {
+	Id           string
+	Identifier   string
+	SessionCount int
+	Language     string
+	// Timezone is an offset from UTC in seconds
+	Timezone          int
+	GameVersion       string
+	DeviceOs          string
+	DeviceType        int
+	DeviceModel       string
+	AdId              string
+	Tags              map[string]string
+	LastActive        time.Time
+	Playtime          int
+	AmountSpent       float64
+	CreatedAt         time.Time
+	InvalidIdentifier bool
+	BadgeCount        int
+	ExternalUserId    string
+	Country           string
+	WebAuth           string
+	WebP256           string
+}
+
+type FieldError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("error while parsing %s (%s): %s", e.Field, e.Value, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+type FieldErrors []*FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Field returns an error of the field or nil
+func (e FieldErrors) Field(field string) *FieldError {
+	for _, err := range e {
+		if err.Field == field {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParsePlayerRecord parses all known columns, empty values are left zero except required id and last_active,
+// unparsable fields are left zero too and reported in FieldErrors. last_active is parsed with timestamps
+// (the default parser if nil), created_at is always in OneSignal format.
+func ParsePlayerRecord(pd PlayerData, timestamps *TimestampParser) (PlayerRecord, FieldErrors) {
+	if timestamps == nil {
+		timestamps = NewTimestampParser()
+	}
+	p := &recordParser{data: pd, timestamps: timestamps}
+	r := PlayerRecord{
+		Id:                p.required("id"),
+		Identifier:        pd["identifier"],
+		SessionCount:      p.int("session_count"),
+		Language:          pd["language"],
+		Timezone:          p.int("timezone"),
+		GameVersion:       pd["game_version"],
+		DeviceOs:          pd["device_os"],
+		DeviceType:        p.int("device_type"),
+		DeviceModel:       pd["device_model"],
+		AdId:              pd["ad_id"],
+		Tags:              p.tags("tags"),
+		LastActive:        p.timestamp("last_active"),
+		Playtime:          p.int("playtime"),
+		AmountSpent:       p.float("amount_spent"),
+		CreatedAt:         p.time("created_at"),
+		InvalidIdentifier: p.bool("invalid_identifier"),
+		BadgeCount:        p.int("badge_count"),
+		ExternalUserId:    pd["external_user_id"],
+		Country:           pd["country"],
+		WebAuth:           pd["web_auth"],
+		WebP256:           pd["web_p256"],
+	}
+	return r, p.errors
+}
+
+type recordParser struct {
+	data       PlayerData
+	timestamps *TimestampParser
+	errors     FieldErrors
+}
+
+func (p *recordParser) fail(field string, err error) {
+	p.errors = append(p.errors, &FieldError{Field: field, Value: p.data[field], Err: err})
+}
+
+func (p *recordParser) required(field string) string {
+	v := p.data[field]
+	if v == "" {
+		p.fail(field, errors.New("empty value"))
+	}
+	return v
+}
+
+func (p *recordParser) int(field string) int {
+	v := p.data[field]
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		p.fail(field, err)
+		return 0
+	}
+	return i
+}
+
+func (p *recordParser) float(field string) float64 {
+	v := p.data[field]
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		p.fail(field, err)
+		return 0
+	}
+	return f
+}
+
+func (p *recordParser) bool(field string) bool {
+	v := p.data[field]
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.fail(field, err)
+		return false
+	}
+	return b
+}
+
+func (p *recordParser) time(field string) time.Time {
+	v := p.data[field]
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(OneSignalTimeLayout, v)
+	if err != nil {
+		p.fail(field, err)
+		return time.Time{}
+	}
+	return t
+}
+
+func (p *recordParser) timestamp(field string) time.Time {
+	v := p.data[field]
+	if v == "" {
+		p.fail(field, errors.New("empty value"))
+		return time.Time{}
+	}
+	t, err := p.timestamps.Parse(v)
+	if err != nil {
+		p.fail(field, err)
+		return time.Time{}
+	}
+	return t
+}
+
+func (p *recordParser) tags(field string) map[string]string {
+	v := p.data[field]
+	if v == "" {
+		return nil
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(v), &raw); err != nil {
+		p.fail(field, err)
+		return nil
+	}
+	tags := make(map[string]string, len(raw))
+	for k, tag := range raw {
+		if s, ok := tag.(string); ok {
+			tags[k] = s
+		} else {
+			tags[k] = fmt.Sprint(tag)
+		}
+	}
+	return tags
+}
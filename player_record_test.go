@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParsePlayerRecord(t *testing.T) {
+	r, errs := ParsePlayerRecord(PlayerData{
+		"id":                 "059f4d57-xxxx-xxxx-xxxx-fa83792fd276",
+		"identifier":         "token",
+		"session_count":      "12",
+		"language":           "en",
+		"timezone":           "-28800",
+		"game_version":       "1.2.3",
+		"device_os":          "14.4",
+		"device_type":        "0",
+		"device_model":       "iPhone12,1",
+		"ad_id":              "",
+		"tags":               `{"level":"10","vip":true}`,
+		"last_active":        "2018-10-26 08:48:42",
+		"playtime":           "3600",
+		"amount_spent":       "9.99",
+		"created_at":         "2017-01-02 03:04:05",
+		"invalid_identifier": "f",
+		"badge_count":        "1",
+		"external_user_id":   "user-1",
+		"country":            "US",
+	}, nil)
+	assert.Empty(t, errs)
+	assert.Equal(t, PlayerRecord{
+		Id:                "059f4d57-xxxx-xxxx-xxxx-fa83792fd276",
+		Identifier:        "token",
+		SessionCount:      12,
+		Language:          "en",
+		Timezone:          -28800,
+		GameVersion:       "1.2.3",
+		DeviceOs:          "14.4",
+		DeviceType:        0,
+		DeviceModel:       "iPhone12,1",
+		Tags:              map[string]string{"level": "10", "vip": "true"},
+		LastActive:        time.Date(2018, 10, 26, 8, 48, 42, 0, time.UTC),
+		Playtime:          3600,
+		AmountSpent:       9.99,
+		CreatedAt:         time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+		InvalidIdentifier: false,
+		BadgeCount:        1,
+		ExternalUserId:    "user-1",
+		Country:           "US",
+	}, r)
+}
+
+func TestParsePlayerRecord_FieldErrors(t *testing.T) {
+	r, errs := ParsePlayerRecord(PlayerData{
+		"id":            "id1",
+		"session_count": "many",
+		"tags":          "{",
+	}, nil)
+	assert.Equal(t, "id1", r.Id)
+	assert.Len(t, errs, 3)
+	assert.NotNil(t, errs.Field("session_count"))
+	assert.NotNil(t, errs.Field("tags"))
+	assert.EqualError(t, errs.Field("last_active"), "error while parsing last_active (): empty value")
+	assert.Nil(t, errs.Field("id"))
+}
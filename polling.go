@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// PollingStrategy returns a pause before the next readiness check, attempt starts with 1
+type PollingStrategy interface {
+	Pause(attempt int) time.Duration
+}
+
+type FixedPolling struct {
+	Interval time.Duration
+}
+
+func (s FixedPolling) Pause(attempt int) time.Duration {
+	return s.Interval
+}
+
+// ExponentialPolling multiplies Initial pause by Multiplier on every attempt up to Max
+type ExponentialPolling struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+func (s ExponentialPolling) Pause(attempt int) time.Duration {
+	pause := float64(s.Initial) * math.Pow(s.Multiplier, float64(attempt-1))
+	if s.Max > 0 && pause > float64(s.Max) {
+		return s.Max
+	}
+	return time.Duration(pause)
+}
+
+// JitteredPolling randomizes a pause of the underlying Strategy by +/- Jitter (a fraction from 0 to 1)
+type JitteredPolling struct {
+	Strategy PollingStrategy
+	Jitter   float64
+	Rand     func() float64
+}
+
+func (s JitteredPolling) Pause(attempt int) time.Duration {
+	random := s.Rand
+	if random == nil {
+		random = rand.Float64
+	}
+	pause := float64(s.Strategy.Pause(attempt))
+	return time.Duration(pause + pause*s.Jitter*(2*random()-1))
+}
+
+// NewPollingStrategy creates a strategy by its name: fixed, exponential or jittered (exponential with jitter)
+func NewPollingStrategy(name string, interval time.Duration, maxInterval time.Duration) (PollingStrategy, error) {
+	switch strings.ToLower(name) {
+	case "fixed", "":
+		return FixedPolling{Interval: interval}, nil
+	case "exponential":
+		return ExponentialPolling{Initial: interval, Max: maxInterval, Multiplier: 2}, nil
+	case "jittered":
+		return JitteredPolling{
+			Strategy: ExponentialPolling{Initial: interval, Max: maxInterval, Multiplier: 2},
+			Jitter:   0.2,
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown polling strategy: %s", name)
+	}
+}
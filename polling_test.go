@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestExponentialPolling(t *testing.T) {
+	s := ExponentialPolling{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}
+	assert.Equal(t, time.Second, s.Pause(1))
+	assert.Equal(t, 2*time.Second, s.Pause(2))
+	assert.Equal(t, 8*time.Second, s.Pause(4))
+	assert.Equal(t, 10*time.Second, s.Pause(5))
+}
+
+func TestJitteredPolling(t *testing.T) {
+	s := JitteredPolling{
+		Strategy: FixedPolling{Interval: 10 * time.Second},
+		Jitter:   0.5,
+		Rand: func() float64 {
+			return 1
+		},
+	}
+	assert.Equal(t, 15*time.Second, s.Pause(1))
+	s.Rand = func() float64 {
+		return 0
+	}
+	assert.Equal(t, 5*time.Second, s.Pause(1))
+}
+
+func TestNewPollingStrategy(t *testing.T) {
+	s, err := NewPollingStrategy("exponential", time.Second, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, ExponentialPolling{Initial: time.Second, Max: time.Minute, Multiplier: 2}, s)
+	_, err = NewPollingStrategy("foobar", time.Second, time.Minute)
+	assert.Error(t, err)
+}
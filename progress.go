@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mingalevme/gologger"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ProgressMode string
+
+const (
+	ProgressModeNone ProgressMode = "none"
+	ProgressModeLog  ProgressMode = "log"
+	ProgressModeBar  ProgressMode = "bar"
+	ProgressModeAuto ProgressMode = "auto"
+)
+
+func ParseProgressMode(mode string) (ProgressMode, error) {
+	switch ProgressMode(strings.ToLower(mode)) {
+	case ProgressModeNone:
+		return ProgressModeNone, nil
+	case ProgressModeLog:
+		return ProgressModeLog, nil
+	case ProgressModeBar:
+		return ProgressModeBar, nil
+	case ProgressModeAuto, "":
+		if isTerminal(os.Stderr) {
+			return ProgressModeBar, nil
+		}
+		return ProgressModeLog, nil
+	default:
+		return "", errors.Errorf("unknown progress mode: %s", mode)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+type ProgressState interface {
+	Fields() gologger.Fields
+	Line() string
+}
+
+// ProgressReporter reports a progress state not more often than once per Interval,
+// either as a log record or as a progress bar redrawn in Writer (usually a terminal).
+type ProgressReporter struct {
+	Mode     ProgressMode
+	Interval time.Duration
+	Writer   io.Writer
+	Logger   gologger.Logger
+	mu       sync.Mutex
+	last     time.Time
+}
+
+func NewProgressReporter(logger gologger.Logger) *ProgressReporter {
+	return &ProgressReporter{
+		Mode:     ProgressModeLog,
+		Interval: 10 * time.Second,
+		Writer:   os.Stderr,
+		Logger:   logger,
+	}
+}
+
+func (p *ProgressReporter) Report(message string, state ProgressState) {
+	if p == nil || p.Mode == ProgressModeNone {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if now.Sub(p.last) < p.Interval {
+		return
+	}
+	p.last = now
+	p.report(message, state, false)
+}
+
+// Done reports the final state regardless of Interval
+func (p *ProgressReporter) Done(message string, state ProgressState) {
+	if p == nil || p.Mode == ProgressModeNone {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = time.Time{}
+	p.report(message, state, true)
+}
+
+func (p *ProgressReporter) report(message string, state ProgressState, done bool) {
+	if p.Mode == ProgressModeBar {
+		eol := ""
+		if done {
+			eol = "\n"
+		}
+		_, _ = fmt.Fprintf(p.Writer, "\r\033[K%s: %s%s", message, state.Line(), eol)
+		return
+	}
+	p.Logger.WithFields(state.Fields()).Infof("%s", message)
+}
+
+type DownloadProgress struct {
+	// Total is negative if the size is unknown
+	Total    int
+	Received int
+	Elapsed  time.Duration
+}
+
+func (s DownloadProgress) Fields() gologger.Fields {
+	fields := gologger.Fields{
+		"received":   s.Received,
+		"throughput": s.throughput(),
+	}
+	if s.Total >= 0 {
+		fields["total"] = s.Total
+		fields["percent"] = fmt.Sprintf("%.2f", s.percent())
+		fields["eta"] = s.eta().String()
+	}
+	return fields
+}
+
+func (s DownloadProgress) Line() string {
+	if s.Total < 0 {
+		return fmt.Sprintf("%s %s", formatBytes(float64(s.Received)), s.throughput())
+	}
+	return fmt.Sprintf("%s %5.1f%% %s / %s %s ETA %s",
+		progressBar(s.percent(), 30),
+		s.percent(),
+		formatBytes(float64(s.Received)),
+		formatBytes(float64(s.Total)),
+		s.throughput(),
+		s.eta())
+}
+
+func (s DownloadProgress) percent() float64 {
+	if s.Total <= 0 {
+		return 100
+	}
+	return float64(s.Received) * 100 / float64(s.Total)
+}
+
+func (s DownloadProgress) throughput() string {
+	if s.Elapsed <= 0 {
+		return "n/a"
+	}
+	return formatBytes(float64(s.Received)/s.Elapsed.Seconds()) + "/s"
+}
+
+func (s DownloadProgress) eta() time.Duration {
+	if s.Received <= 0 || s.Total <= s.Received {
+		return 0
+	}
+	rate := float64(s.Received) / s.Elapsed.Seconds()
+	return (time.Duration(float64(s.Total-s.Received)/rate) * time.Second).Round(time.Second)
+}
+
+// ProcessingProgress counters are safe for concurrent use
+type ProcessingProgress struct {
+	Rows     int64
+	Inactive int64
+	Deleted  int64
+	// Edited are inactive players soft-deleted instead of the deletion (see ActionMode)
+	Edited int64
+	// Skipped are inactive players skipped by the freshness check, already (soft-)deleted or of users with active players
+	Skipped   int64
+	Errors    int64
+	StartedAt time.Time
+}
+
+func NewProcessingProgress() *ProcessingProgress {
+	return &ProcessingProgress{
+		StartedAt: time.Now(),
+	}
+}
+
+func (s *ProcessingProgress) AddRow() {
+	atomic.AddInt64(&s.Rows, 1)
+}
+
+func (s *ProcessingProgress) AddInactive() {
+	atomic.AddInt64(&s.Inactive, 1)
+}
+
+func (s *ProcessingProgress) AddDeleted() {
+	atomic.AddInt64(&s.Deleted, 1)
+}
+
+func (s *ProcessingProgress) AddEdited() {
+	atomic.AddInt64(&s.Edited, 1)
+}
+
+func (s *ProcessingProgress) AddSkipped() {
+	atomic.AddInt64(&s.Skipped, 1)
+}
+
+func (s *ProcessingProgress) AddError() {
+	atomic.AddInt64(&s.Errors, 1)
+}
+
+func (s *ProcessingProgress) Snapshot() ProcessingProgress {
+	return ProcessingProgress{
+		Rows:      atomic.LoadInt64(&s.Rows),
+		Inactive:  atomic.LoadInt64(&s.Inactive),
+		Deleted:   atomic.LoadInt64(&s.Deleted),
+		Edited:    atomic.LoadInt64(&s.Edited),
+		Skipped:   atomic.LoadInt64(&s.Skipped),
+		Errors:    atomic.LoadInt64(&s.Errors),
+		StartedAt: s.StartedAt,
+	}
+}
+
+func (s *ProcessingProgress) Fields() gologger.Fields {
+	snapshot := s.Snapshot()
+	return gologger.Fields{
+		"rows":              snapshot.Rows,
+		"rows-per-sec":      fmt.Sprintf("%.1f", snapshot.rate(snapshot.Rows)),
+		"inactive":          snapshot.Inactive,
+		"deleted":           snapshot.Deleted,
+		"deletions-per-sec": fmt.Sprintf("%.1f", snapshot.rate(snapshot.Deleted)),
+		"edited":            snapshot.Edited,
+		"skipped":           snapshot.Skipped,
+		"errors":            snapshot.Errors,
+	}
+}
+
+func (s *ProcessingProgress) Line() string {
+	snapshot := s.Snapshot()
+	return fmt.Sprintf("rows %d (%.1f/s), inactive %d, deleted %d (%.1f/s), errors %d",
+		snapshot.Rows,
+		snapshot.rate(snapshot.Rows),
+		snapshot.Inactive,
+		snapshot.Deleted,
+		snapshot.rate(snapshot.Deleted),
+		snapshot.Errors)
+}
+
+func (s *ProcessingProgress) rate(n int64) float64 {
+	elapsed := time.Since(s.StartedAt).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / elapsed
+}
+
+func progressBar(percent float64, width int) string {
+	filled := int(percent / 100 * float64(width))
+	if filled > width {
+		filled = width
+	}
+	if filled < 0 {
+		filled = 0
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "]"
+}
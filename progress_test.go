@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"github.com/mingalevme/gologger"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReporter_Report(t *testing.T) {
+	logger := gologger.NewArrayLogger(gologger.LevelInfo)
+	p := NewProgressReporter(logger)
+	p.Interval = time.Hour
+	state := DownloadProgress{Total: 200, Received: 50, Elapsed: time.Second}
+	p.Report("Downloading", state)
+	p.Report("Downloading", state)
+	p.Done("Downloaded", state)
+	assert.Len(t, logger.Storage(), 2)
+}
+
+func TestProgressReporter_Bar(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p := NewProgressReporter(gologger.NewNullLogger())
+	p.Mode = ProgressModeBar
+	p.Writer = buf
+	p.Done("Downloading", DownloadProgress{Total: 2048, Received: 1024, Elapsed: time.Second})
+	assert.True(t, strings.HasSuffix(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), " 50.0% 1.0 KiB / 2.0 KiB 1.0 KiB/s ETA 1s")
+	buf.Reset()
+	p.Done("Downloading", DownloadProgress{Total: -1, Received: 1024, Elapsed: time.Second})
+	assert.Contains(t, buf.String(), "Downloading: 1.0 KiB 1.0 KiB/s")
+}
+
+func TestProcessingProgress(t *testing.T) {
+	s := NewProcessingProgress()
+	s.AddRow()
+	s.AddRow()
+	s.AddInactive()
+	s.AddDeleted()
+	s.AddError()
+	fields := s.Fields()
+	assert.Equal(t, int64(2), fields["rows"])
+	assert.Equal(t, int64(1), fields["inactive"])
+	assert.Equal(t, int64(1), fields["deleted"])
+	assert.Equal(t, int64(1), fields["errors"])
+	assert.Contains(t, s.Line(), "rows 2 ")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type RunReport struct {
+	AppId       string    `json:"app_id"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	InactiveFor int       `json:"inactive_for"`
+	Source      string    `json:"source,omitempty"`
+	DataFile    string    `json:"data_file,omitempty"`
+	Rows        int64     `json:"rows"`
+	Inactive    int64     `json:"inactive"`
+	Deleted     int64     `json:"deleted"`
+	Edited      int64     `json:"edited"`
+	Skipped     int64     `json:"skipped"`
+	Errors      int64     `json:"errors"`
+	// Verified is a number of sampled deleted players confirmed not to exist anymore by the deletions verification
+	Verified           int      `json:"verified,omitempty"`
+	NotDeleted         []string `json:"not_deleted,omitempty"`
+	Redeleted          int      `json:"redeleted,omitempty"`
+	VerificationErrors int      `json:"verification_errors,omitempty"`
+	Success            bool     `json:"success"`
+	Error              string   `json:"error,omitempty"`
+}
+
+func NewRunReport(appId string, inactiveFor int, startedAt int) *RunReport {
+	return &RunReport{
+		AppId:       appId,
+		StartedAt:   time.Unix(int64(startedAt), 0).UTC(),
+		InactiveFor: inactiveFor,
+	}
+}
+
+func (r *RunReport) SetStats(stats ProcessingProgress) {
+	r.Rows = stats.Rows
+	r.Inactive = stats.Inactive
+	r.Deleted = stats.Deleted
+	r.Edited = stats.Edited
+	r.Skipped = stats.Skipped
+	r.Errors = stats.Errors
+}
+
+func (r *RunReport) Finish(finishedAt int, err error) {
+	r.FinishedAt = time.Unix(int64(finishedAt), 0).UTC()
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+func (r *RunReport) FileName() string {
+	return fmt.Sprintf("onesignal-cleaner-report-%s-%s.json", r.AppId, r.StartedAt.Format(exportTimeLayout))
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/mingalevme/gologger"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveSink stores data files and run reports outside the host, e.g. for compliance
+type ArchiveSink interface {
+	UploadFile(key string, filename string) error
+	Upload(key string, data []byte, contentType string) error
+}
+
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Sink uploads objects to an S3-compatible storage (AWS S3, MinIO, etc.) signing requests with AWS Signature V4.
+// Objects are uploaded with a single PUT-request, so an object size is limited to 5 GiB.
+type S3Sink struct {
+	// Endpoint is an origin of the storage, e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Endpoint        string
+	Region          string
+	Bucket          string
+	Prefix          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	// ServerSideEncryption is a value of x-amz-server-side-encryption-header: AES256 or aws:kms
+	ServerSideEncryption string
+	SSEKMSKeyId          string
+	// PathStyle addresses the bucket as Endpoint/Bucket/key instead of Bucket.Endpoint-host/key
+	PathStyle     bool
+	AppHttpClient AppHttpClient
+	Logger        gologger.Logger
+	Now           func() time.Time
+}
+
+func NewS3Sink(endpoint string, region string, bucket string) *S3Sink {
+	return &S3Sink{
+		Endpoint:      strings.TrimRight(endpoint, "/"),
+		Region:        region,
+		Bucket:        bucket,
+		PathStyle:     true,
+		AppHttpClient: http.DefaultClient,
+		Logger:        gologger.NewStdoutLogger(gologger.LevelInfo),
+		Now:           time.Now,
+	}
+}
+
+func (s *S3Sink) UploadFile(key string, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrapf(err, "error while opening a file to upload: %s", filename)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	stat, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "error while getting a file to upload stats: %s", filename)
+	}
+	return s.upload(key, f, stat.Size(), "application/gzip")
+}
+
+func (s *S3Sink) Upload(key string, data []byte, contentType string) error {
+	return s.upload(key, bytes.NewReader(data), int64(len(data)), contentType)
+}
+
+func (s *S3Sink) upload(key string, body io.Reader, size int64, contentType string) error {
+	key = s.objectKey(key)
+	exists, err := s.exists(key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		s.Logger.WithField("bucket", s.Bucket).WithField("key", key).Infof("Object already exists, skipping uploading")
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPut, s.objectUrl(key), ioutil.NopCloser(body))
+	if err != nil {
+		return errors.Wrap(err, "error while creating an object uploading request")
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if s.ServerSideEncryption != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", s.ServerSideEncryption)
+	}
+	if s.SSEKMSKeyId != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.SSEKMSKeyId)
+	}
+	s.sign(req, s3UnsignedPayload)
+	s.Logger.WithField("bucket", s.Bucket).WithField("key", key).WithField("size", size).Infof("Uploading an object ...")
+	resp, err := s.AppHttpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error while uploading an object: %s", key)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("error response (code: %d) while uploading an object (%s): %s", resp.StatusCode, key, string(respBody))
+	}
+	s.Logger.WithField("bucket", s.Bucket).WithField("key", key).Infof("Object has been uploaded")
+	return nil
+}
+
+func (s *S3Sink) exists(key string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, s.objectUrl(key), nil)
+	if err != nil {
+		return false, errors.Wrap(err, "error while creating an object checking request")
+	}
+	s.sign(req, emptyPayloadHash())
+	resp, err := s.AppHttpClient.Do(req)
+	if err != nil {
+		return false, errors.Wrapf(err, "error while checking an object: %s", key)
+	}
+	_ = resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, errors.Errorf("error response (code: %d) while checking an object: %s", resp.StatusCode, key)
+	}
+}
+
+func (s *S3Sink) objectKey(key string) string {
+	if s.Prefix == "" {
+		return key
+	}
+	return strings.Trim(s.Prefix, "/") + "/" + key
+}
+
+func (s *S3Sink) objectUrl(key string) string {
+	if s.PathStyle {
+		return s.Endpoint + "/" + s.Bucket + "/" + s3EscapePath(key)
+	}
+	scheme := "https://"
+	host := s.Endpoint
+	if i := strings.Index(host, "://"); i >= 0 {
+		scheme = host[:i+3]
+		host = host[i+3:]
+	}
+	return scheme + s.Bucket + "." + host + "/" + s3EscapePath(key)
+}
+
+// sign adds AWS Signature V4 Authorization-header to the request
+func (s *S3Sink) sign(req *http.Request, payloadHash string) {
+	now := s.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" || name == "content-md5" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	key := hmacSha256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSha256(key, s.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyId, scope, signedHeaders, signature))
+}
+
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			_, _ = fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func emptyPayloadHash() string {
+	return sha256Hex(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
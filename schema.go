@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OneSignalExportColumns are columns of a OneSignal CSV export including optional extra fields
+var OneSignalExportColumns = []string{
+	"id",
+	"identifier",
+	"session_count",
+	"language",
+	"timezone",
+	"game_version",
+	"device_os",
+	"device_type",
+	"device_model",
+	"ad_id",
+	"tags",
+	"last_active",
+	"playtime",
+	"amount_spent",
+	"created_at",
+	"invalid_identifier",
+	"badge_count",
+	"location",
+	"country",
+	"rooted",
+	"ip",
+	"web_auth",
+	"web_p256",
+	"unsubscribed_at",
+	"notification_types",
+	"external_user_id",
+}
+
+type HeaderSchemaError struct {
+	Missing    []string
+	Unexpected []string
+}
+
+func (e *HeaderSchemaError) Error() string {
+	msg := fmt.Sprintf("invalid data file header, missing columns: %s", strings.Join(e.Missing, ", "))
+	if len(e.Unexpected) > 0 {
+		msg += fmt.Sprintf("; unexpected columns: %s", strings.Join(e.Unexpected, ", "))
+	}
+	return msg
+}
+
+// ValidateHeader returns *HeaderSchemaError if any of the required columns is missing,
+// unexpected columns (not required and not known) are returned regardless of the error
+func ValidateHeader(header []string, required []string, known []string) ([]string, error) {
+	present := map[string]bool{}
+	for _, column := range header {
+		present[column] = true
+	}
+	expected := map[string]bool{}
+	for _, column := range known {
+		expected[column] = true
+	}
+	var missing []string
+	for _, column := range required {
+		expected[column] = true
+		if !present[column] {
+			missing = append(missing, column)
+		}
+	}
+	var unexpected []string
+	for _, column := range header {
+		if !expected[column] {
+			unexpected = append(unexpected, column)
+		}
+	}
+	if len(missing) > 0 {
+		return unexpected, &HeaderSchemaError{Missing: missing, Unexpected: unexpected}
+	}
+	return unexpected, nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateHeader(t *testing.T) {
+	unexpected, err := ValidateHeader([]string{"id", "last_active", "foo"}, []string{"id", "last_active"}, OneSignalExportColumns)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, unexpected)
+
+	unexpected, err = ValidateHeader([]string{"id", "last_seen", "identifier"}, []string{"id", "last_active"}, OneSignalExportColumns)
+	assert.EqualError(t, err, "invalid data file header, missing columns: last_active; unexpected columns: last_seen")
+	assert.Equal(t, []string{"last_seen"}, unexpected)
+}
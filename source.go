@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/mingalevme/gologger"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SourceMode defines where players are read from
+type SourceMode string
+
+const (
+	// SourceModeExport reads players from a CSV export
+	SourceModeExport SourceMode = "export"
+	// SourceModeApi reads players page by page with View devices API
+	SourceModeApi SourceMode = "api"
+	// SourceModeAuto reads players from a CSV export falling back to View devices API if the export fails
+	SourceModeAuto SourceMode = "auto"
+)
+
+// DefaultViewPlayersLimit is the max page size of View devices API
+const DefaultViewPlayersLimit = 300
+
+func ParseSourceMode(mode string) (SourceMode, error) {
+	switch SourceMode(strings.ToLower(mode)) {
+	case SourceModeExport, "":
+		return SourceModeExport, nil
+	case SourceModeApi:
+		return SourceModeApi, nil
+	case SourceModeAuto:
+		return SourceModeAuto, nil
+	default:
+		return "", errors.Errorf("unknown source mode: %s", mode)
+	}
+}
+
+// PlayerSource provides players as rows with CSV export columns (see OneSignalExportColumns),
+// so players of all the sources are parsed to the same records.
+type PlayerSource interface {
+	Name() string
+	Open() (RowReader, error)
+}
+
+// dataFileSource is a source reading a data file
+type dataFileSource interface {
+	DataFile() string
+}
+
+// finishingSource is finished after all the players have been handled successfully
+type finishingSource interface {
+	Finish()
+}
+
+// NewPlayerSource returns a source of the mode
+func (c *Cleaner) NewPlayerSource(mode SourceMode) PlayerSource {
+	switch mode {
+	case SourceModeApi:
+		return NewApiSource(c.OneSignalClient, c.Logger)
+	case SourceModeAuto:
+		return NewFallbackSource(c.Logger, NewExportSource(c), NewApiSource(c.OneSignalClient, c.Logger))
+	default:
+		return NewExportSource(c)
+	}
+}
+
+// cleanSource handles players of the source
+func (c *Cleaner) cleanSource(report *RunReport, source PlayerSource) error {
+	r, err := source.Open()
+	if err != nil {
+		return err
+	}
+	report.Source = source.Name()
+	if s, ok := source.(dataFileSource); ok {
+		report.DataFile = s.DataFile()
+	}
+	stats, err := c.handlePlayers(r)
+	// Closed before finishing, e.g. the data file is deleted
+	r.Close()
+	report.SetStats(stats)
+	if err != nil {
+		return err
+	}
+	c.Logger.Infof("Cleaning has been finished: %d players have been deleted", stats.Deleted)
+	if s, ok := source.(finishingSource); ok {
+		s.Finish()
+	}
+	return nil
+}
+
+// ExportSource reads players from a CSV export, a cached one is reused if MaxExportAge allows it
+type ExportSource struct {
+	cleaner  *Cleaner
+	fileName string
+}
+
+func NewExportSource(c *Cleaner) *ExportSource {
+	return &ExportSource{cleaner: c}
+}
+
+func (s *ExportSource) Name() string {
+	return string(SourceModeExport)
+}
+
+func (s *ExportSource) Open() (RowReader, error) {
+	c := s.cleaner
+	if e, ok := c.getCachedExport(); ok {
+		c.Logger.
+			WithField("file", e.Filename).
+			WithField("created-at", e.CreatedAt.String()).
+			Infof("Reusing a cached data file")
+		s.fileName = e.Filename
+	} else {
+		fileName, err := c.fetchData()
+		if err != nil {
+			return nil, errors.Wrap(err, "error while fetching a data file")
+		}
+		c.uploadDataFile(fileName)
+		s.fileName = fileName
+	}
+	c.Logger.Infof("Starting data file reading ...")
+	r, err := c.GzCsvReaderFactory(s.fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "error while creating/initializing gz-csv-reader")
+	}
+	return r, nil
+}
+
+func (s *ExportSource) DataFile() string {
+	return s.fileName
+}
+
+// Finish deletes the data file if DeleteDataFile is set and applies the data files retention policy
+func (s *ExportSource) Finish() {
+	c := s.cleaner
+	if c.DeleteDataFile {
+		if err := os.Remove(s.fileName); err != nil {
+			c.Logger.WithField("file", s.fileName).WithError(err).Errorf("Error while deleting a data file")
+		} else {
+			c.Logger.WithField("file", s.fileName).Infof("Data file has been deleted")
+		}
+	} else {
+		c.Logger.Infof("Consider deleting a data file: %s", s.fileName)
+	}
+	c.applyRetention()
+}
+
+// ApiSource reads players page by page with View devices API, e.g. to clean a small app without waiting
+// for an export. All the pages are fetched before the handling, as deletions would shift offsets of the rest
+// of players, so players are kept in memory.
+type ApiSource struct {
+	OneSignalClient *OneSignalClient
+	Logger          gologger.Logger
+	// Limit is a page size, 300 at most
+	Limit int
+}
+
+func NewApiSource(client *OneSignalClient, logger gologger.Logger) *ApiSource {
+	return &ApiSource{
+		OneSignalClient: client,
+		Logger:          logger,
+		Limit:           DefaultViewPlayersLimit,
+	}
+}
+
+func (s *ApiSource) Name() string {
+	return string(SourceModeApi)
+}
+
+func (s *ApiSource) Open() (RowReader, error) {
+	s.Logger.WithField("limit", s.Limit).Infof("Fetching players with View devices API ...")
+	var ids []string
+	rows := map[string]PlayerData{}
+	offset := 0
+	for {
+		page, err := s.OneSignalClient.ViewPlayers(s.Limit, offset)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while fetching players")
+		}
+		for _, player := range page.Players {
+			pd := playerDataFromJson(player)
+			// Players added while paginating shift the rest of players, so a player can be returned twice
+			if _, ok := rows[pd["id"]]; !ok {
+				ids = append(ids, pd["id"])
+			}
+			rows[pd["id"]] = pd
+		}
+		offset += len(page.Players)
+		s.Logger.
+			WithField("offset", offset).
+			WithField("total-count", page.TotalCount).
+			Debugf("Page of players has been fetched")
+		if len(page.Players) == 0 || offset >= page.TotalCount {
+			break
+		}
+	}
+	s.Logger.WithField("players", len(ids)).Infof("Players have been fetched")
+	return &sliceRowReader{ids: ids, rows: rows}, nil
+}
+
+// playerDataFromJson converts a View device response player to an export row: numbers and booleans
+// are formatted, objects (tags) are JSON-encoded, last_active and created_at are formatted as in exports (UTC).
+func playerDataFromJson(player map[string]interface{}) PlayerData {
+	pd := PlayerData{}
+	for key, value := range player {
+		switch v := value.(type) {
+		case nil:
+			pd[key] = ""
+		case string:
+			pd[key] = v
+		case json.Number:
+			pd[key] = v.String()
+		case bool:
+			pd[key] = strconv.FormatBool(v)
+		default:
+			b, _ := json.Marshal(v)
+			pd[key] = string(b)
+		}
+	}
+	for _, key := range []string{"last_active", "created_at"} {
+		if n, err := strconv.ParseInt(pd[key], 10, 64); err == nil {
+			pd[key] = time.Unix(n, 0).UTC().Format(OneSignalTimeLayout)
+		}
+	}
+	return pd
+}
+
+// FallbackSource opens the sources in order until one of them is opened successfully,
+// e.g. View devices API is used if an export is not ready within ReadinessTimeout.
+type FallbackSource struct {
+	Sources []PlayerSource
+	Logger  gologger.Logger
+	active  PlayerSource
+}
+
+func NewFallbackSource(logger gologger.Logger, sources ...PlayerSource) *FallbackSource {
+	return &FallbackSource{
+		Sources: sources,
+		Logger:  logger,
+	}
+}
+
+// Name returns the name of the opened source
+func (s *FallbackSource) Name() string {
+	if s.active != nil {
+		return s.active.Name()
+	}
+	var names []string
+	for _, source := range s.Sources {
+		names = append(names, source.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (s *FallbackSource) Open() (RowReader, error) {
+	var errs []string
+	for i, source := range s.Sources {
+		r, err := source.Open()
+		if err == nil {
+			s.active = source
+			return r, nil
+		}
+		errs = append(errs, source.Name()+": "+err.Error())
+		if i < len(s.Sources)-1 {
+			s.Logger.WithField("source", source.Name()).WithError(err).Warningf("Error while opening a player source, falling back")
+		}
+	}
+	return nil, errors.Errorf("error while opening player sources: %s", strings.Join(errs, "; "))
+}
+
+func (s *FallbackSource) DataFile() string {
+	if ds, ok := s.active.(dataFileSource); ok {
+		return ds.DataFile()
+	}
+	return ""
+}
+
+func (s *FallbackSource) Finish() {
+	if fs, ok := s.active.(finishingSource); ok {
+		fs.Finish()
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/mingalevme/gologger"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"onesignal-cleaner/fakeonesignal"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readRecords(t *testing.T, r RowReader) map[string]PlayerRecord {
+	defer r.Close()
+	records := map[string]PlayerRecord{}
+	for {
+		pd, err := r.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		record, errs := ParsePlayerRecord(pd, nil)
+		assert.Empty(t, errs)
+		records[record.Id] = record
+	}
+	return records
+}
+
+func TestApiSource_Open(t *testing.T) {
+	rows := [][]string{
+		{"id", "identifier", "session_count", "timezone", "device_type", "tags", "last_active", "amount_spent", "created_at", "invalid_identifier", "country", "external_user_id"},
+		{"id1", "token1", "10", "10800", "1", `{"level":"5"}`, "2020-01-01 00:00:00", "1.5", "2019-01-01 12:00:00", "f", "RU", ""},
+		{"id2", "token2", "1", "-3600", "0", "{}", "2021-06-01 12:30:00", "0", "2021-05-01 00:00:00", "t", "US", "user2"},
+		{"id3", "", "2", "0", "5", `{"a":"b","c":"d"}`, "2022-02-02 02:02:02", "", "2022-01-01 00:00:00", "f", "", ""},
+	}
+	filename := t.TempDir() + "/export.csv.gz"
+	writeGzCsv(t, filename, rows)
+
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	assert.NoError(t, server.SeedFile(filename))
+	server.Start()
+	defer server.Close()
+
+	client := NewOneSignalClient("app-id", "rest-api-key")
+	client.OriginUrl = server.URL()
+	source := NewApiSource(client, gologger.NewNullLogger())
+	source.Limit = 2
+	r, err := source.Open()
+	assert.NoError(t, err)
+	fromApi := readRecords(t, r)
+
+	export, err := NewGzCsvReader(filename, DefaultCsvOptions())
+	assert.NoError(t, err)
+	fromExport := readRecords(t, export)
+
+	assert.Len(t, fromApi, 3)
+	assert.Equal(t, fromExport, fromApi)
+	assert.Equal(t, []string{"GET /api/v1/players", "GET /api/v1/players"}, server.Requests())
+
+	// Raw rows (archived by file and webhook actions) have timestamps in the export format
+	r, err = source.Open()
+	assert.NoError(t, err)
+	defer r.Close()
+	pd, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "2020-01-01 00:00:00", pd["last_active"])
+	assert.Equal(t, "2019-01-01 12:00:00", pd["created_at"])
+}
+
+func TestCleaner_Clean_SourceFallback(t *testing.T) {
+	now := time.Now()
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	server.AddPlayer("inactive", now.AddDate(-1, 0, 0))
+	server.AddPlayer("active", now)
+	server.InjectFault(http.MethodPost, "/api/v1/players/csv_export", fakeonesignal.Fault{Status: http.StatusInternalServerError})
+	server.Start()
+	defer server.Close()
+
+	sink := &memoryArchiveSink{objects: map[string][]byte{}}
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.OriginUrl = server.URL()
+	cleaner.TmpDir = t.TempDir()
+	cleaner.InactiveFor = 86400 * 30
+	cleaner.Sink = sink
+	cleaner.Source = cleaner.NewPlayerSource(SourceModeAuto)
+
+	assert.NoError(t, cleaner.Clean())
+	assert.Equal(t, []string{"inactive"}, server.Deleted())
+
+	var report RunReport
+	for key, data := range sink.objects {
+		if strings.HasPrefix(key, "app-id/onesignal-cleaner-report-") {
+			assert.NoError(t, json.Unmarshal(data, &report))
+		}
+	}
+	assert.Equal(t, "api", report.Source)
+	assert.Equal(t, "", report.DataFile)
+	assert.Equal(t, int64(1), report.Deleted)
+}
+
+func TestParseSourceMode(t *testing.T) {
+	mode, err := ParseSourceMode("API")
+	assert.NoError(t, err)
+	assert.Equal(t, SourceModeApi, mode)
+	mode, err = ParseSourceMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, SourceModeExport, mode)
+	_, err = ParseSourceMode("players")
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimestampParser parses timestamps in one of Layouts (tried in order) or, if none matches, Unix epoch seconds/milliseconds,
+// timestamps without a timezone are considered to be in Location.
+type TimestampParser struct {
+	Layouts  []string
+	Location *time.Location
+}
+
+func NewTimestampParser() *TimestampParser {
+	return &TimestampParser{
+		Layouts:  []string{OneSignalTimeLayout, time.RFC3339},
+		Location: time.UTC,
+	}
+}
+
+// epochMillisThreshold separates epoch seconds from milliseconds: 10^11 seconds is year 5138,
+// 10^11 milliseconds is year 1973
+const epochMillisThreshold = 100000000000
+
+func (p *TimestampParser) Parse(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	location := p.Location
+	if location == nil {
+		location = time.UTC
+	}
+	var lastErr error
+	// Layouts are tried before epoch, so all-digit layouts (e.g. 20060102150405) are not taken for epoch
+	for _, layout := range p.Layouts {
+		t, err := time.ParseInLocation(layout, v, location)
+		if err == nil {
+			return t, nil
+		}
+		lastErr = err
+	}
+	if isEpoch(v) {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "error while parsing epoch timestamp: %s", v)
+		}
+		if n >= epochMillisThreshold || n <= -epochMillisThreshold {
+			return time.Unix(0, n*int64(time.Millisecond)).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no layouts")
+	}
+	return time.Time{}, errors.Wrapf(lastErr, "timestamp matches none of the layouts: %s", v)
+}
+
+func isEpoch(v string) bool {
+	v = strings.TrimPrefix(v, "-")
+	if v == "" {
+		return false
+	}
+	for _, c := range v {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FutureLastActivePolicy defines how to handle a player with last_active in the future
+type FutureLastActivePolicy string
+
+const (
+	// FutureLastActiveActive considers the player active
+	FutureLastActiveActive FutureLastActivePolicy = "active"
+	// FutureLastActiveSkip skips the player reporting an error
+	FutureLastActiveSkip FutureLastActivePolicy = "skip"
+	// FutureLastActiveInactive considers the player inactive
+	FutureLastActiveInactive FutureLastActivePolicy = "inactive"
+)
+
+func ParseFutureLastActivePolicy(policy string) (FutureLastActivePolicy, error) {
+	switch FutureLastActivePolicy(strings.ToLower(policy)) {
+	case FutureLastActiveActive:
+		return FutureLastActiveActive, nil
+	case FutureLastActiveSkip:
+		return FutureLastActiveSkip, nil
+	case FutureLastActiveInactive:
+		return FutureLastActiveInactive, nil
+	default:
+		return "", errors.Errorf("unknown future last_active policy: %s", policy)
+	}
+}
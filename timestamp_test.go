@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTimestampParser_Parse(t *testing.T) {
+	p := NewTimestampParser()
+	expected := time.Date(2018, 10, 26, 8, 48, 42, 0, time.UTC)
+	for _, v := range []string{
+		"2018-10-26 08:48:42",
+		"2018-10-26T08:48:42Z",
+		"2018-10-26T11:48:42+03:00",
+		"1540543722",
+		"1540543722000",
+	} {
+		actual, err := p.Parse(v)
+		assert.NoError(t, err, v)
+		assert.True(t, expected.Equal(actual), v)
+	}
+	_, err := p.Parse("26/10/2018")
+	assert.Error(t, err)
+}
+
+func TestTimestampParser_Location(t *testing.T) {
+	p := NewTimestampParser()
+	p.Location = time.FixedZone("UTC+3", 3*3600)
+	actual, err := p.Parse("2018-10-26 11:48:42")
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2018, 10, 26, 8, 48, 42, 0, time.UTC).Equal(actual))
+}
+
+func TestTimestampParser_NumericLayout(t *testing.T) {
+	p := NewTimestampParser()
+	p.Layouts = append(p.Layouts, "20060102150405")
+	actual, err := p.Parse("20181026084842")
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2018, 10, 26, 8, 48, 42, 0, time.UTC).Equal(actual), actual.String())
+	// Epoch still parses if the numeric layout does not match
+	actual, err = p.Parse("1540543722")
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2018, 10, 26, 8, 48, 42, 0, time.UTC).Equal(actual), actual.String())
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+)
+
+// DeletionTarget defines what is deleted for an inactive player: the legacy player or an entity of User Model
+type DeletionTarget string
+
+const (
+	// DeletionTargetPlayer deletes the player with the legacy players API
+	DeletionTargetPlayer DeletionTarget = "player"
+	// DeletionTargetSubscription deletes the subscription of User Model (its ID equals to the player ID)
+	DeletionTargetSubscription DeletionTarget = "subscription"
+	// DeletionTargetUser deletes the user of User Model with all its subscriptions by the UserAlias
+	DeletionTargetUser DeletionTarget = "user"
+)
+
+const (
+	AliasExternalId  = "external_id"
+	AliasOneSignalId = "onesignal_id"
+)
+
+func ParseDeletionTarget(target string) (DeletionTarget, error) {
+	switch DeletionTarget(strings.ToLower(target)) {
+	case DeletionTargetPlayer, "":
+		return DeletionTargetPlayer, nil
+	case DeletionTargetSubscription:
+		return DeletionTargetSubscription, nil
+	case DeletionTargetUser:
+		return DeletionTargetUser, nil
+	default:
+		return "", errors.Errorf("unknown deletion target: %s", target)
+	}
+}
+
+// deleteTarget deletes the player, its subscription or its user according to Target
+func (c *Cleaner) deleteTarget(p Player) error {
+	switch c.Target {
+	case DeletionTargetSubscription:
+		return c.OneSignalClient.DeleteSubscription(p.Id)
+	case DeletionTargetUser:
+		aliasId := c.userAliasId(p)
+		if aliasId == "" {
+			return errors.Errorf("player has no %s alias", c.UserAlias)
+		}
+		return c.OneSignalClient.DeleteUser(c.UserAlias, aliasId)
+	default:
+		return c.OneSignalClient.DeletePlayer(p.Id)
+	}
+}
+
+// deleteTargetOnce deletes the target like deleteTarget, but a user is deleted once for all its players
+// and the result of the deletion is returned for every one of them, see userDeletions
+func (c *Cleaner) deleteTargetOnce(p Player) error {
+	aliasId := c.userAliasId(p)
+	if c.Target != DeletionTargetUser || aliasId == "" {
+		return c.deleteTarget(p)
+	}
+	return c.userDeletions.delete(aliasId, func() error {
+		return c.deleteTarget(p)
+	})
+}
+
+// isNotFound reports whether the User Model deletion error means the target does not exist anymore, e.g. a user
+// has been deleted with another subscription or an asynchronous (202) deletion has been finished
+func isNotFound(err error) bool {
+	return err == ErrUserNotFound || err == ErrSubscriptionNotFound
+}
+
+// userAliasId returns the UserAlias value of the player, see aliasId
+func (c *Cleaner) userAliasId(p Player) string {
+	if v := aliasId(c.UserAlias, p.Data); v != "" {
+		return v
+	}
+	if c.UserAlias == AliasExternalId {
+		return p.Record.ExternalUserId
+	}
+	return ""
+}
+
+// aliasId returns the alias value of the player data, it is a column of the same name,
+// external_id falls back to external_user_id column of legacy exports.
+func aliasId(label string, pd PlayerData) string {
+	if v := pd[label]; v != "" {
+		return v
+	}
+	if label == AliasExternalId {
+		return pd["external_user_id"]
+	}
+	return ""
+}
+
+// userGroups groups inactive players by the user alias, a user is deleted with all its subscriptions,
+// so it is deleted only if all its players are inactive. Inactive players are kept in memory until
+// the whole data is read. It is safe for concurrent use, a nil *userGroups does not group players.
+type userGroups struct {
+	alias    string
+	mu       sync.Mutex
+	active   map[string]bool
+	inactive map[string][]Player
+	// order keeps users in the order of the data to handle them predictably
+	order []string
+}
+
+// newUserGroups returns nil unless the deletion target is DeletionTargetUser
+func (c *Cleaner) newUserGroups() *userGroups {
+	if c.Target != DeletionTargetUser {
+		return nil
+	}
+	return &userGroups{
+		alias:    c.UserAlias,
+		active:   map[string]bool{},
+		inactive: map[string][]Player{},
+	}
+}
+
+// protect marks the user of the player data as having an active player, the data must be of a player which is
+// not inactive or has not been evaluated (e.g. an invalid last_active), so its user is never deleted.
+func (g *userGroups) protect(pd PlayerData) {
+	if g == nil {
+		return
+	}
+	id := aliasId(g.alias, pd)
+	if id == "" {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active[id] = true
+}
+
+// postpone adds the inactive player to its user group, players without the alias are not postponed
+func (g *userGroups) postpone(p Player) bool {
+	if g == nil {
+		return false
+	}
+	id := aliasId(g.alias, p.Data)
+	if id == "" {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.inactive[id]; !ok {
+		g.order = append(g.order, id)
+	}
+	g.inactive[id] = append(g.inactive[id], p)
+	return true
+}
+
+// userDeletions remembers results of user deletions, a user is deleted once for all its players: User Model
+// deletions are asynchronous (202), so a repeated deletion is not a 404 and would be counted again.
+// It is safe for concurrent use.
+type userDeletions struct {
+	mu        sync.Mutex
+	deletions map[string]*userDeletion
+}
+
+type userDeletion struct {
+	once sync.Once
+	err  error
+}
+
+// delete calls deleteUser for the first player of the user and returns its result for all the others
+func (d *userDeletions) delete(id string, deleteUser func() error) error {
+	d.mu.Lock()
+	if d.deletions == nil {
+		d.deletions = map[string]*userDeletion{}
+	}
+	deletion, ok := d.deletions[id]
+	if !ok {
+		deletion = &userDeletion{}
+		d.deletions[id] = deletion
+	}
+	d.mu.Unlock()
+	deletion.once.Do(func() {
+		deletion.err = deleteUser()
+	})
+	return deletion.err
+}
+
+// handleUserGroups handles postponed players of users without active players, players of a user are handled
+// sequentially and share the result of a single user deletion (see userDeletions).
+// It must be called after the whole data has been read.
+func (c *Cleaner) handleUserGroups(g *userGroups, stats *ProcessingProgress) {
+	if g == nil {
+		return
+	}
+	throttle := make(chan struct{}, c.Concurrency)
+	wg := sync.WaitGroup{}
+	for _, id := range g.order {
+		players := g.inactive[id]
+		if g.active[id] {
+			c.Logger.
+				WithField(g.alias, id).
+				WithField("inactive", len(players)).
+				Infof("User has active players, skipping")
+			for range players {
+				stats.AddSkipped()
+			}
+			continue
+		}
+		throttle <- struct{}{}
+		wg.Add(1)
+		go func(players []Player) {
+			defer func() {
+				<-throttle
+				wg.Done()
+			}()
+			for _, p := range players {
+				c.handleInactivePlayer(p, stats)
+			}
+		}(players)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/mingalevme/gologger"
+	"github.com/stretchr/testify/assert"
+	"onesignal-cleaner/fakeonesignal"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleaner_Clean_DeletionTarget(t *testing.T) {
+	for _, tc := range []struct {
+		target  DeletionTarget
+		alias   string
+		deleted []string
+		players []string
+		stats   ProcessingProgress
+		// users are DELETE requests of users, a user is deleted once for all its players
+		users []string
+	}{
+		{DeletionTargetSubscription, "", []string{"sub1", "sub2", "sub3", "sub4", "sub5"}, []string{"sub6"}, ProcessingProgress{Deleted: 5}, nil},
+		{DeletionTargetUser, AliasExternalId, []string{"sub1", "sub2"}, []string{"sub3", "sub4", "sub5", "sub6"}, ProcessingProgress{Deleted: 2, Skipped: 1, Errors: 2},
+			[]string{"DELETE /api/v1/apps/app-id/users/by/external_id/user1"}},
+		{DeletionTargetUser, AliasOneSignalId, []string{"sub1", "sub2", "sub3"}, []string{"sub4", "sub5", "sub6"}, ProcessingProgress{Deleted: 3, Skipped: 1, Errors: 1},
+			[]string{"DELETE /api/v1/apps/app-id/users/by/onesignal_id/os1", "DELETE /api/v1/apps/app-id/users/by/onesignal_id/os3"}},
+	} {
+		filename := t.TempDir() + "/export.csv.gz"
+		writeGzCsv(t, filename, [][]string{
+			{"id", "last_active", "external_user_id", "onesignal_id"},
+			{"sub1", "2020-01-01 00:00:00", "user1", "os1"},
+			{"sub2", "2020-01-01 00:00:00", "user1", "os1"},
+			{"sub3", "2020-01-01 00:00:00", "", "os3"},
+			{"sub4", "2020-01-01 00:00:00", "", ""},
+			{"sub5", "2020-01-01 00:00:00", "user5", "os5"},
+			// user5 has an active subscription, so it is not deleted
+			{"sub6", time.Now().UTC().Format(OneSignalTimeLayout), "user5", "os5"},
+		})
+		server := fakeonesignal.NewServer("app-id", "rest-api-key")
+		assert.NoError(t, server.SeedFile(filename))
+		server.Start()
+
+		cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+		cleaner.OneSignalClient.OriginUrl = server.URL()
+		cleaner.Target = tc.target
+		if tc.alias != "" {
+			cleaner.UserAlias = tc.alias
+		}
+		r, err := cleaner.GzCsvReaderFactory(filename)
+		assert.NoError(t, err)
+		stats, err := cleaner.handlePlayers(r)
+		r.Close()
+		server.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.deleted, server.Deleted(), string(tc.target))
+		assert.Equal(t, tc.players, server.Players(), string(tc.target))
+		assert.Equal(t, tc.stats.Deleted, stats.Deleted, string(tc.target))
+		assert.Equal(t, tc.stats.Skipped, stats.Skipped, string(tc.target))
+		assert.Equal(t, tc.stats.Errors, stats.Errors, string(tc.target))
+		var users []string
+		for _, request := range server.Requests() {
+			if strings.HasPrefix(request, "DELETE /api/v1/apps/app-id/users/") {
+				users = append(users, request)
+			}
+		}
+		assert.Equal(t, tc.users, users, string(tc.target))
+	}
+}
+
+func TestCleaner_Clean_DeletionTarget_AlreadyDeleted(t *testing.T) {
+	filename := t.TempDir() + "/export.csv.gz"
+	writeGzCsv(t, filename, [][]string{
+		{"id", "last_active"},
+		{"sub1", "2020-01-01 00:00:00"},
+		{"sub2", "2020-01-01 00:00:00"},
+	})
+	server := fakeonesignal.NewServer("app-id", "rest-api-key")
+	assert.NoError(t, server.SeedFile(filename))
+	server.Start()
+	defer server.Close()
+
+	cleaner := NewCleaner("app-id", "rest-api-key", gologger.NewNullLogger())
+	cleaner.OneSignalClient.OriginUrl = server.URL()
+	cleaner.Target = DeletionTargetSubscription
+	assert.NoError(t, cleaner.OneSignalClient.DeleteSubscription("sub2"))
+	r, err := cleaner.GzCsvReaderFactory(filename)
+	assert.NoError(t, err)
+	defer r.Close()
+	stats, err := cleaner.handlePlayers(r)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Deleted)
+	assert.Equal(t, int64(1), stats.Skipped)
+	assert.Equal(t, int64(0), stats.Errors)
+}
+
+func TestParseDeletionTarget(t *testing.T) {
+	target, err := ParseDeletionTarget("User")
+	assert.NoError(t, err)
+	assert.Equal(t, DeletionTargetUser, target)
+	target, err = ParseDeletionTarget("")
+	assert.NoError(t, err)
+	assert.Equal(t, DeletionTargetPlayer, target)
+	_, err = ParseDeletionTarget("device")
+	assert.Error(t, err)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// deletionSample collects a random sample of deleted players for the verification, it is safe for concurrent use
+type deletionSample struct {
+	mu      sync.Mutex
+	players []Player
+}
+
+func (s *deletionSample) add(p Player, ratio float64) {
+	if ratio <= 0 || (ratio < 1 && rand.Float64() >= ratio) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.players = append(s.players, p)
+}
+
+func (s *deletionSample) take() []Player {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	players := s.players
+	s.players = nil
+	return players
+}
+
+// verifyDeletions re-fetches sampled deleted players after VerifyDeletionsDelay reporting the ones still existing,
+// their deletion is retried if RetryUndeleted is set. Only players not found anymore are counted as verified.
+func (c *Cleaner) verifyDeletions(report *RunReport) {
+	players := c.deletions.take()
+	if len(players) == 0 {
+		return
+	}
+	c.Logger.
+		WithField("players", len(players)).
+		WithField("delay", c.VerifyDeletionsDelay.String()).
+		Infof("Waiting before deletions verification ...")
+	time.Sleep(c.VerifyDeletionsDelay)
+	mu := sync.Mutex{}
+	throttle := make(chan struct{}, c.Concurrency)
+	wg := sync.WaitGroup{}
+	for _, p := range players {
+		throttle <- struct{}{}
+		wg.Add(1)
+		go func(p Player) {
+			defer func() {
+				<-throttle
+				wg.Done()
+			}()
+			_, err := c.OneSignalClient.GetPlayer(p.Id)
+			if err == ErrPlayerNotFound {
+				mu.Lock()
+				report.Verified++
+				mu.Unlock()
+				return
+			}
+			if err != nil {
+				c.Logger.WithField("id", p.Id).WithError(err).Errorf("Error while verifying a player deletion")
+				mu.Lock()
+				report.VerificationErrors++
+				mu.Unlock()
+				return
+			}
+			c.Logger.
+				WithField("id", p.Id).
+				WithField("last-active", p.LastActive.String()).
+				Warningf("Deleted player still exists")
+			redeleted := false
+			if c.RetryUndeleted {
+				// Not deletePlayer: the player is already in the journal and the retry is not sampled
+				err := c.deleteTarget(p)
+				if isNotFound(err) {
+					c.Logger.WithField("id", p.Id).Infof("Player deletion has been finished meanwhile")
+					mu.Lock()
+					report.Verified++
+					mu.Unlock()
+					return
+				}
+				if err != nil {
+					c.Logger.WithField("id", p.Id).WithError(err).Errorf("Error while retrying a player deletion")
+				} else {
+					c.Logger.WithField("id", p.Id).Infof("Player deletion has been retried")
+					redeleted = true
+				}
+			}
+			mu.Lock()
+			report.NotDeleted = append(report.NotDeleted, p.Id)
+			if redeleted {
+				report.Redeleted++
+			}
+			mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+	c.Logger.
+		WithField("verified", report.Verified).
+		WithField("not-deleted", len(report.NotDeleted)).
+		WithField("redeleted", report.Redeleted).
+		WithField("errors", report.VerificationErrors).
+		Infof("Deletions have been verified")
+}